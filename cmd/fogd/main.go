@@ -0,0 +1,33 @@
+/*
+	fogd - the search service of the fog node
+	By Hongcheng Xie at Department of Computer Science, City University of Hong Kong, Hong Kong SAR, China
+
+	Usage: fogd [-addr :8080] [-maxBodySize bytes] [-workers n] [-searchTimeout duration]. See package fog for the endpoints.
+*/
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/JerryXie96/PPRQueryIoT/fog"
+)
+
+var (
+	addr          = flag.String("addr", ":8080", "the address to listen on")
+	maxBodySize   = flag.Int64("maxBodySize", fog.DefaultMaxBodySize, "the limit of the request body in bytes")
+	workers       = flag.Int("workers", 0, "the number of workers of one search (0: the number of CPUs)")
+	searchTimeout = flag.Duration("searchTimeout", 0, "the deadline of one search (0: none)")
+)
+
+// main(): the main function
+func main() {
+	flag.Parse()
+	s := fog.NewServer()
+	s.MaxBodySize = *maxBodySize
+	s.Workers = *workers
+	s.SearchTimeout = *searchTimeout
+	log.Printf("fogd listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, s))
+}
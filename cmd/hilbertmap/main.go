@@ -0,0 +1,91 @@
+/*
+	hilbertmap - convert the test data in two-dimensional space to the coordinates in one-dimensional space
+	By Hongcheng Xie at Department of Computer Science, City University of Hong Kong, Hong Kong SAR, China
+
+	Usage: hilbertmap [-order n] [-dims n] [-curve hilbert|z] [-in 2d.data] [-out 1d.data]
+
+	The input is the number of points followed by the dims coordinates of each point (e.g. "x y", or "x y time"), and the output is the distance of each point along the curve of a grid with 2^order cells on each side, one per line (the data file of the PC prototype).
+	The 2-D Hilbert curve is the curve of hilbert.Curve, and the others are hilbert.HilbertND and hilbert.ZOrder.
+*/
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/JerryXie96/PPRQueryIoT/hilbert"
+)
+
+var (
+	order  = flag.Int("order", 8, "the number of bits of one coordinate, i.e. the space is divided into 2^order cells on each side")
+	dims   = flag.Int("dims", 2, "the number of coordinates of one point")
+	curve  = flag.String("curve", "hilbert", "the space-filling curve (hilbert or z)")
+	input  = flag.String("in", "2d.data", "the test data in 2-D space")
+	output = flag.String("out", "1d.data", "the processed data")
+)
+
+// mapping(): create the mapping chosen by the flags
+func mapping() (hilbert.Mapping, error) {
+	switch *curve {
+	case "hilbert":
+		if *dims == 2 {
+			return hilbert.New(*order)
+		}
+		return hilbert.NewHilbertND(*dims, *order)
+	case "z":
+		return hilbert.NewZOrder(*dims, *order)
+	}
+	return nil, fmt.Errorf("unknown curve %q (want hilbert or z)", *curve)
+}
+
+// convert(hilbert.Mapping, io.Reader, io.Writer): read the points of m.Dims() coordinates from r and write their distances along m to w
+func convert(m hilbert.Mapping, r io.Reader, w io.Writer) error {
+	var num int // the number of points
+	in := bufio.NewReader(r)
+	if _, err := fmt.Fscan(in, &num); err != nil {
+		return fmt.Errorf("reading the number of points: %w", err)
+	}
+	out := bufio.NewWriter(w)
+	for i := 0; i < num; i++ {
+		coords := make([]uint64, m.Dims())
+		for j := range coords {
+			if _, err := fmt.Fscan(in, &coords[j]); err != nil {
+				return fmt.Errorf("reading point %d: %w", i+1, err)
+			}
+		}
+		d, err := m.Encode(coords)
+		if err != nil {
+			return fmt.Errorf("point %d: %w", i+1, err)
+		}
+		fmt.Fprintln(out, d)
+	}
+	return out.Flush()
+}
+
+// main(): main function
+func main() {
+	flag.Parse()
+	m, err := mapping()
+	if err != nil {
+		log.Fatal(err)
+	}
+	in, err := os.Open(*input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := convert(m, in, out); err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
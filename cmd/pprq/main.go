@@ -0,0 +1,345 @@
+/*
+	pprq - the tools of the data owner
+	By Hongcheng Xie at Department of Computer Science, City University of Hong Kong, Hong Kong SAR, China
+
+	Usage:
+		pprq keygen -o file [-passphraseEnv name]                                   generate a key and write its export (sealed with the passphrase in the environment variable name, raw otherwise)
+		pprq rotate -index url -from file -to file [-passphraseEnv name] [-batch n] [-passes n]
+		                                                                            re-encrypt the index on the fog node (e.g. http://fog.local:8080/indexes/temp) from one key to another in batches, in passes until no item of the retiring key is left
+		pprq verify -index url|file -key file [-passphraseEnv name] [-lower n -upper n | -queries n] [-seed n] [-v]
+		                                                                            run queries on the index and compare the results with the plaintext values recovered from the payloads, reporting precision, recall and the mismatching items
+*/
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/JerryXie96/PPRQueryIoT/pprq"
+)
+
+// command: one subcommand
+type command struct {
+	name  string                    // the name of the subcommand
+	usage string                    // the one-line usage
+	run   func(args []string) error // run the subcommand with its arguments
+}
+
+var commands = []command{
+	{"keygen", "keygen -o file [-passphraseEnv name]", keygen},
+	{"rotate", "rotate -index url -from file -to file [-passphraseEnv name] [-batch n] [-passes n]", rotate},
+	{"verify", "verify -index url|file -key file [-passphraseEnv name] [-lower n -upper n | -queries n] [-seed n] [-v]", verify},
+}
+
+// usage(): print the usage of all the subcommands and exit
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	for _, cmd := range commands {
+		fmt.Fprintln(os.Stderr, "\tpprq", cmd.usage)
+	}
+	os.Exit(2)
+}
+
+// keygen([]string): generate a key and write its export to the file
+func keygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := fs.String("o", "", "the file to write the key to")
+	passphraseEnv := fs.String("passphraseEnv", "", "the environment variable holding the passphrase which seals the key (empty: write the raw key)")
+	fs.Parse(args)
+	if *out == "" {
+		return errors.New("keygen: missing -o")
+	}
+
+	key, err := pprq.GenerateKey()
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if *passphraseEnv != "" {
+		passphrase := os.Getenv(*passphraseEnv)
+		if passphrase == "" {
+			return fmt.Errorf("keygen: environment variable %s is empty", *passphraseEnv)
+		}
+		data, err = key.Seal([]byte(passphrase))
+	} else {
+		data, err = key.MarshalBinary()
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0o600); err != nil {
+		return err
+	}
+	fmt.Println(key.ID())
+	return nil
+}
+
+// loadKey(string, string): read the key exported by keygen (sealed if passphraseEnv is not empty, raw otherwise)
+func loadKey(name string, passphraseEnv string) (*pprq.Key, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if passphraseEnv != "" {
+		return pprq.OpenKey(data, []byte(os.Getenv(passphraseEnv)))
+	}
+	var key pprq.Key
+	if err := key.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &key, nil
+}
+
+// checkResponse(*http.Response, error): turn a failed request into an error
+func checkResponse(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// fetchIndex(string): read the index from the fog node (an http or https URL) or from a file written by Index.WriteTo
+func fetchIndex(src string) (*pprq.Index, error) {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := checkResponse(http.Get(src))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return pprq.ReadIndex(resp.Body)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return pprq.ReadIndex(f)
+}
+
+// rotate([]string): re-encrypt the index on the fog node from one key to another. the batches replace the items in place, so the index stays searchable (with one token per key) during the rotation. each pass works on a fresh copy of the index, and a batch whose items were updated or deleted on the fog node since the copy (412 or 404) is left to the next pass, so no update is undone and the items appended with the retiring key are rotated too
+func rotate(args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	index := fs.String("index", "", "the URL of the index on the fog node")
+	fromFile := fs.String("from", "", "the file of the retiring key")
+	toFile := fs.String("to", "", "the file of the new key")
+	passphraseEnv := fs.String("passphraseEnv", "", "the environment variable holding the passphrase of both keys (empty: raw keys)")
+	batch := fs.Int("batch", 256, "the number of items re-encrypted in one batch")
+	passes := fs.Int("passes", 10, "the max number of passes (the devices still using the retiring key keep adding items to rotate)")
+	fs.Parse(args)
+	if *index == "" || *fromFile == "" || *toFile == "" {
+		return errors.New("rotate: missing -index, -from or -to")
+	}
+	base, err := url.Parse(*index)
+	if err != nil {
+		return err
+	}
+	fromKey, err := loadKey(*fromFile, *passphraseEnv)
+	if err != nil {
+		return err
+	}
+	toKey, err := loadKey(*toFile, *passphraseEnv)
+	if err != nil {
+		return err
+	}
+
+	idx, err := fetchIndex(base.String())
+	if err != nil {
+		return err
+	}
+	from, err := pprq.NewSchemeWithKey(fromKey, idx.Params())
+	if err != nil {
+		return err
+	}
+	to, err := pprq.NewSchemeWithKey(toKey, idx.Params())
+	if err != nil {
+		return err
+	}
+	rotation, err := pprq.NewRotation(from, to)
+	if err != nil {
+		return err
+	}
+
+	itemsURL := base.JoinPath("items")
+	for pass := 1; ; pass++ {
+		if pass > 1 {
+			if idx, err = fetchIndex(base.String()); err != nil {
+				return err
+			}
+		}
+		remaining := rotation.Remaining(idx)
+		if remaining == 0 {
+			fmt.Printf("rotated all %d items to key %s\n", idx.Len(), toKey.ID())
+			return nil
+		}
+		if pass > *passes {
+			return fmt.Errorf("rotate: %d items still encrypted with key %s after %d passes", remaining, fromKey.ID(), *passes)
+		}
+		fmt.Printf("pass %d: rotating %d of %d items from key %s to key %s\n", pass, remaining, idx.Len(), fromKey.ID(), toKey.ID())
+
+		rotation.Restart()
+		for {
+			ids, revisions, items, err := rotation.Next(idx, *batch)
+			if err != nil {
+				return err
+			}
+			if len(items) == 0 {
+				break
+			}
+			var buf bytes.Buffer
+			enc := pprq.NewEncoder(&buf, idx.Params())
+			for i := range items {
+				if err := enc.Encode(&items[i]); err != nil {
+					return err
+				}
+			}
+			idFields := make([]string, len(ids))
+			revFields := make([]string, len(ids))
+			for i, id := range ids {
+				idFields[i], revFields[i] = id.String(), revisions[i].String()
+			}
+			req, err := http.NewRequest(http.MethodPut, itemsURL.String()+"?ids="+strings.Join(idFields, ",")+"&revs="+strings.Join(revFields, ","), &buf)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/octet-stream")
+			resp, err := http.DefaultClient.Do(req)
+			if err == nil && (resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusNotFound) { // updated or deleted since the copy
+				resp.Body.Close()
+				fmt.Printf("items %s to %s changed during the pass, left to the next pass\n", ids[0], ids[len(ids)-1])
+				continue
+			}
+			if resp, err = checkResponse(resp, err); err != nil {
+				return err
+			}
+			resp.Body.Close()
+			fmt.Printf("replaced items %s to %s\n", ids[0], ids[len(ids)-1])
+		}
+	}
+}
+
+// ratio(int, int): n/d as in VerifyReport.Precision and VerifyReport.Recall (1 if d is 0)
+func ratio(n int, d int) float64 {
+	if d == 0 {
+		return 1
+	}
+	return float64(n) / float64(d)
+}
+
+// verify([]string): check the search on a real index against the plaintext. the values are recovered from the payloads of the items of the key, so no other copy of the dataset is needed
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	index := fs.String("index", "", "the URL of the index on the fog node, or the file of the index")
+	keyFile := fs.String("key", "", "the file of the key")
+	passphraseEnv := fs.String("passphraseEnv", "", "the environment variable holding the passphrase of the key (empty: raw key)")
+	lower := fs.Uint64("lower", 0, "the lower bound of the query (with -upper)")
+	upper := fs.Uint64("upper", 0, "the upper bound of the query (with -lower)")
+	queries := fs.Int("queries", 100, "the number of random queries if -lower and -upper are not set")
+	seed := fs.Uint64("seed", 1, "the seed of the random queries")
+	verbose := fs.Bool("v", false, "print the report of every query, not only of the failed ones")
+	fs.Parse(args)
+	if *index == "" || *keyFile == "" {
+		return errors.New("verify: missing -index or -key")
+	}
+	bounded := false // whether -lower or -upper is set
+	fs.Visit(func(f *flag.Flag) {
+		bounded = bounded || f.Name == "lower" || f.Name == "upper"
+	})
+
+	key, err := loadKey(*keyFile, *passphraseEnv)
+	if err != nil {
+		return err
+	}
+	idx, err := fetchIndex(*index)
+	if err != nil {
+		return err
+	}
+	scheme, err := pprq.NewSchemeWithKey(key, idx.Params())
+	if err != nil {
+		return err
+	}
+	values, err := scheme.PlainValues(idx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("verifying %d of %d items (key %s)\n", len(values), idx.Len(), key.ID())
+
+	// the random bounds are drawn from the stored values (where an off-by-one would show) and from the whole domain in turn
+	var stored []uint64
+	for _, v := range values {
+		stored = append(stored, v)
+	}
+	r := rand.New(rand.NewPCG(*seed, 0))
+	bound := func(i int) uint64 {
+		if i%2 == 0 && len(stored) > 0 {
+			return stored[r.IntN(len(stored))]
+		}
+		return r.Uint64() >> (64 - idx.Params().DomainBits)
+	}
+	if bounded {
+		*queries = 1
+	}
+
+	var returned, expected, falsePositives, falseNegatives int
+	for i := 0; i < *queries; i++ {
+		lo, hi := *lower, *upper
+		if !bounded {
+			lo, hi = bound(i), bound(i)
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+		report, err := scheme.Verify(idx, values, lo, hi)
+		if err != nil {
+			return err
+		}
+		returned += report.Returned
+		expected += report.Expected
+		falsePositives += len(report.FalsePositives)
+		falseNegatives += len(report.FalseNegatives)
+		if *verbose || !report.OK() {
+			fmt.Println(report)
+		}
+		for _, m := range report.FalsePositives {
+			fmt.Printf("\tfalse positive: item %s, value %d\n", m.ID, m.Value)
+		}
+		for _, m := range report.FalseNegatives {
+			fmt.Printf("\tfalse negative: item %s, value %d\n", m.ID, m.Value)
+		}
+	}
+
+	fmt.Printf("%d queries: returned %d, expected %d, precision %.4f, recall %.4f\n", *queries, returned, expected, ratio(returned-falsePositives, returned), ratio(expected-falseNegatives, expected))
+	if falsePositives > 0 || falseNegatives > 0 {
+		return fmt.Errorf("verify: %d false positives, %d false negatives", falsePositives, falseNegatives)
+	}
+	return nil
+}
+
+// main(): the main function
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+}
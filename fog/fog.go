@@ -0,0 +1,483 @@
+/*
+	fog.go - the search service of the fog node
+	By Hongcheng Xie at Department of Computer Science, City University of Hong Kong, Hong Kong SAR, China
+
+	Package fog holds the encrypted indexes uploaded by the IoT devices and evaluates the query tokens generated by the data owner. It never sees the key.
+
+	Endpoints (the index and the item encodings are the binary formats of package pprq):
+		PUT    /indexes/{name}             upload (or replace) a whole index
+		GET    /indexes/{name}             download the whole index
+		DELETE /indexes/{name}             remove the index
+		POST   /indexes/{name}/items       append a stream of items written by pprq.Encoder and return their IDs (the index is created if it does not exist). a request with the header Idempotency-Key is appended once: a repeat of its key returns the response of the first one
+		PUT    /indexes/{name}/items       replace the items with the IDs ?ids=1,2,... by a stream of items if they still have the revisions ?revs=r1,r2,... (e.g. a batch of pprq.Rotation), or fail with 412 if any of them has been replaced since
+		PUT    /indexes/{name}/items/{id}  replace one item by an item encoded by pprq.IndexCipher.MarshalBinary
+		DELETE /indexes/{name}/items/{id}  delete one item
+		POST   /indexes/{name}/search      evaluate the query tokens, one per key (several tokens of one key return the union of their ranges) (binary by pprq.MarshalTokens, or JSON {"tokens": ["<base64>", ...]} with Content-Type application/json)
+*/
+package fog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JerryXie96/PPRQueryIoT/pprq"
+)
+
+const DefaultMaxBodySize int64 = 64 << 20 // the default limit of the request body (64 MiB)
+
+// Server: the HTTP search service of the fog node. the indexes are locked by themselves, so the searches run in parallel with the appends
+type Server struct {
+	MaxBodySize   int64         // the limit of the request body (0: DefaultMaxBodySize)
+	Workers       int           // the number of workers of one search (0: runtime.GOMAXPROCS)
+	SearchTimeout time.Duration // the deadline of one search (0: no deadline other than the request's)
+
+	mu      sync.RWMutex           // guards indexes
+	indexes map[string]*pprq.Index // the encrypted indexes by name
+	mux     *http.ServeMux         // the routes
+
+	batchMu    sync.Mutex          // guards batches and batchOrder (it is never held across an append)
+	batches    map[batchKey]*batch // the latest appends by their idempotency keys
+	batchOrder []batchKey          // the keys of batches from the oldest, to forget the oldest ones beyond maxBatches
+}
+
+// batch: the append of one idempotency key
+type batch struct {
+	mu   sync.Mutex     // serializes the attempts with the key, so a retry arriving during the first attempt waits for its response
+	done bool           // whether the items have been appended
+	resp AppendResponse // the response of the append (valid if done)
+}
+
+// batchKey: the idempotency key of an append to one index
+type batchKey struct {
+	name string // the name of the index
+	key  string // the value of the header Idempotency-Key
+}
+
+const (
+	IdempotencyHeader string = "Idempotency-Key" // the header which makes an append idempotent (e.g. sent by the retries of iot.Client)
+	maxBatches        int    = 4096              // the number of the latest idempotency keys remembered
+)
+
+// AppendResponse: the response of appending items
+type AppendResponse struct {
+	IDs   []pprq.ItemID `json:"ids"`   // the IDs of the appended (or replaced) items
+	Items int           `json:"items"` // the number of items in the index afterwards
+}
+
+// SearchRequest: the JSON form of the search request
+type SearchRequest struct {
+	Token  *pprq.QueryToken   `json:"token,omitempty"`  // the query token (base64 of its binary form)
+	Tokens []*pprq.QueryToken `json:"tokens,omitempty"` // the query tokens of several keys during the key rotation (used together with Token if both are set)
+}
+
+// SearchResponse: the response of the search
+type SearchResponse struct {
+	IDs      []pprq.ItemID    `json:"ids"`      // the IDs of the matched items
+	Payloads [][]byte         `json:"payloads"` // the sealed payloads of the matched items (base64), in the order of IDs. only the data owner can open them
+	Stats    pprq.SearchStats `json:"stats"`    // the counters of the search
+}
+
+// IndexResponse: the response of uploading an index
+type IndexResponse struct {
+	Items int `json:"items"` // the number of items in the index
+}
+
+// errorResponse: the body of every failed request
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// the errors of the requests
+var (
+	errNotFound   = errors.New("fog: index not found") // the index does not exist
+	errBadRequest = errors.New("fog: bad request")     // the query parameters are invalid
+)
+
+// NewServer(): create a Server without any index
+func NewServer() *Server {
+	s := &Server{indexes: make(map[string]*pprq.Index), mux: http.NewServeMux(), batches: make(map[batchKey]*batch)}
+	s.mux.HandleFunc("PUT /indexes/{name}", s.putIndex)
+	s.mux.HandleFunc("GET /indexes/{name}", s.getIndex)
+	s.mux.HandleFunc("DELETE /indexes/{name}", s.deleteIndex)
+	s.mux.HandleFunc("POST /indexes/{name}/items", s.appendItems)
+	s.mux.HandleFunc("PUT /indexes/{name}/items", s.replaceItems)
+	s.mux.HandleFunc("PUT /indexes/{name}/items/{id}", s.updateItem)
+	s.mux.HandleFunc("DELETE /indexes/{name}/items/{id}", s.deleteItem)
+	s.mux.HandleFunc("POST /indexes/{name}/search", s.search)
+	return s
+}
+
+// ServeHTTP(http.ResponseWriter, *http.Request): dispatch the request to the endpoints
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Index(string): return the index stored under name (nil if it does not exist)
+func (s *Server) Index(name string) *pprq.Index {
+	idx, _ := s.lookup(name)
+	return idx
+}
+
+// lookup(string): return the index stored under name
+func (s *Server) lookup(name string) (*pprq.Index, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx, ok := s.indexes[name]
+	if !ok {
+		return nil, errNotFound
+	}
+	return idx, nil
+}
+
+// body(http.ResponseWriter, *http.Request): limit the request body
+func (s *Server) body(w http.ResponseWriter, r *http.Request) io.Reader {
+	limit := s.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	return http.MaxBytesReader(w, r.Body, limit)
+}
+
+// writeJSON(http.ResponseWriter, int, any): write v as the JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError(http.ResponseWriter, error): map the error to the HTTP status and write it
+func writeError(w http.ResponseWriter, err error) {
+	var (
+		status  = http.StatusInternalServerError
+		tooLong *http.MaxBytesError
+	)
+	switch {
+	case errors.Is(err, errNotFound), errors.Is(err, pprq.ErrNoSuchItem):
+		status = http.StatusNotFound
+	case errors.Is(err, pprq.ErrParamMismatch):
+		status = http.StatusConflict
+	case errors.Is(err, pprq.ErrConflict):
+		status = http.StatusPreconditionFailed
+	case errors.Is(err, pprq.ErrMalformedIndex), errors.Is(err, pprq.ErrMalformedToken), errors.Is(err, pprq.ErrInvalidParams), errors.Is(err, pprq.ErrInvalidKey), errors.Is(err, errBadRequest):
+		status = http.StatusBadRequest
+	case errors.As(err, &tooLong):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// putIndex: upload (or replace) a whole index
+func (s *Server) putIndex(w http.ResponseWriter, r *http.Request) {
+	idx, err := pprq.ReadIndex(s.body(w, r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.mu.Lock()
+	s.indexes[r.PathValue("name")] = idx
+	s.mu.Unlock()
+	s.forgetBatches(r.PathValue("name"))
+	writeJSON(w, http.StatusOK, IndexResponse{Items: idx.Len()})
+}
+
+// getIndex: download the whole index. it is encoded before writing, so a slow client does not hold the lock of the index
+func (s *Server) getIndex(w http.ResponseWriter, r *http.Request) {
+	idx, err := s.lookup(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	data, err := idx.MarshalBinary()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// deleteIndex: remove the index
+func (s *Server) deleteIndex(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	s.mu.Lock()
+	_, ok := s.indexes[name]
+	delete(s.indexes, name)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, errNotFound)
+		return
+	}
+	s.forgetBatches(name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forgetBatches(string): forget the idempotency keys of the index, whose IDs are no longer valid. it must not be called with s.mu held, since an append holds the lock of its batch while it takes s.mu
+func (s *Server) forgetBatches(name string) {
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+	for k := range s.batches {
+		if k.name == name {
+			delete(s.batches, k)
+		}
+	}
+}
+
+// batchOf(batchKey): return the batch of the key, which is created if it is not remembered
+func (s *Server) batchOf(key batchKey) *batch {
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+	b, ok := s.batches[key]
+	if !ok {
+		b = &batch{}
+		s.batches[key] = b
+		s.batchOrder = append(s.batchOrder, key)
+		if len(s.batchOrder) > maxBatches {
+			delete(s.batches, s.batchOrder[0])
+			s.batchOrder = s.batchOrder[1:]
+		}
+	}
+	return b
+}
+
+// readItems(io.Reader): decode a stream of items. an empty body is an empty stream
+func readItems(body io.Reader) (pprq.Params, []pprq.IndexCipher, error) {
+	var items []pprq.IndexCipher
+	dec := pprq.NewDecoder(body)
+	p, err := dec.Params()
+	if err == io.EOF { // nothing in the stream
+		err = nil
+	}
+	for err == nil {
+		var item pprq.IndexCipher
+		if item, err = dec.Decode(); err == nil {
+			items = append(items, item)
+		}
+	}
+	if err != io.EOF {
+		return p, nil, err
+	}
+	return p, items, nil
+}
+
+// appendItems: append a stream of items. the items are decoded before the index is locked, and either all or none of them are appended. the appends with the same idempotency key are serialized, so a retry arriving during the first attempt waits for its response (the appends with other keys do not wait)
+func (s *Server) appendItems(w http.ResponseWriter, r *http.Request) {
+	p, items, err := readItems(s.body(w, r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	key := batchKey{name: r.PathValue("name"), key: r.Header.Get(IdempotencyHeader)}
+	if key.key == "" {
+		resp, err := s.append(key.name, p, items)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	b := s.batchOf(key)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.done { // a failed attempt is not remembered, so its retry appends again
+		resp, err := s.append(key.name, p, items)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		b.resp, b.done = resp, true
+	}
+	writeJSON(w, http.StatusOK, b.resp)
+}
+
+// append(string, pprq.Params, []pprq.IndexCipher): append the items to the index, which is created if it does not exist
+func (s *Server) append(name string, p pprq.Params, items []pprq.IndexCipher) (AppendResponse, error) {
+	s.mu.Lock()
+	idx, ok := s.indexes[name]
+	if !ok && len(items) > 0 {
+		idx, _ = pprq.NewIndex(p) // p has been validated by the decoder
+		s.indexes[name] = idx
+	}
+	s.mu.Unlock()
+	if idx == nil {
+		return AppendResponse{}, errNotFound
+	}
+	ids, err := idx.AppendItems(items)
+	if err != nil {
+		return AppendResponse{}, err
+	}
+	return AppendResponse{IDs: ids, Items: idx.Len()}, nil
+}
+
+// parseID(string): parse one item ID
+func parseID(s string) (pprq.ItemID, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad item ID %q", errBadRequest, s)
+	}
+	return pprq.ItemID(id), nil
+}
+
+// parseRevision(string): parse one item revision
+func parseRevision(s string) (pprq.Revision, error) {
+	rev, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad revision %q", errBadRequest, s)
+	}
+	return pprq.Revision(rev), nil
+}
+
+// replaceItems: replace the items with the IDs in the query by a stream of items if they still have the revisions in the query. either all or none of them are replaced
+func (s *Server) replaceItems(w http.ResponseWriter, r *http.Request) {
+	var (
+		ids       []pprq.ItemID
+		revisions []pprq.Revision
+	)
+	for _, field := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		id, err := parseID(field)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	for _, field := range strings.Split(r.URL.Query().Get("revs"), ",") {
+		rev, err := parseRevision(field)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		revisions = append(revisions, rev)
+	}
+	if len(revisions) != len(ids) {
+		writeError(w, fmt.Errorf("%w: %d revisions for %d IDs", errBadRequest, len(revisions), len(ids)))
+		return
+	}
+	_, items, err := readItems(s.body(w, r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	idx, err := s.lookup(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := idx.Replace(ids, revisions, items); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, AppendResponse{IDs: ids, Items: idx.Len()})
+}
+
+// updateItem: replace one item
+func (s *Server) updateItem(w http.ResponseWriter, r *http.Request) {
+	var item pprq.IndexCipher
+	id, err := parseID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	body, err := io.ReadAll(s.body(w, r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := item.UnmarshalBinary(body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	idx, err := s.lookup(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := idx.Update(id, item); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, AppendResponse{IDs: []pprq.ItemID{id}, Items: idx.Len()})
+}
+
+// deleteItem: delete one item
+func (s *Server) deleteItem(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	idx, err := s.lookup(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := idx.Delete(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// search: evaluate the query tokens on the index
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	var tokens []*pprq.QueryToken
+	body, err := io.ReadAll(s.body(w, r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var req SearchRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			if !errors.Is(err, pprq.ErrMalformedToken) {
+				err = fmt.Errorf("%w: %v", pprq.ErrMalformedToken, err)
+			}
+			writeError(w, err)
+			return
+		}
+		if req.Token != nil {
+			tokens = append(tokens, req.Token)
+		}
+		tokens = append(tokens, req.Tokens...)
+	} else if tokens, err = pprq.UnmarshalTokens(body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	idx, err := s.lookup(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	ctx := r.Context() // the search stops when the client goes away
+	if s.SearchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.SearchTimeout)
+		defer cancel()
+	}
+	var stats pprq.SearchStats
+	ids, payloads, err := idx.SearchContext(ctx, pprq.SearchOptions{Workers: s.Workers, Stats: &stats}, tokens...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if ids == nil {
+		ids, payloads = []pprq.ItemID{}, [][]byte{}
+	}
+	writeJSON(w, http.StatusOK, SearchResponse{IDs: ids, Payloads: payloads, Stats: stats})
+}
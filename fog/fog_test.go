@@ -0,0 +1,608 @@
+package fog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JerryXie96/PPRQueryIoT/pprq"
+)
+
+// newScheme(*testing.T, pprq.Params): create a scheme for the tests
+func newScheme(t *testing.T, p pprq.Params) *pprq.Scheme {
+	t.Helper()
+	s, err := pprq.NewScheme(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// do(*testing.T, string, string, string, []byte, any): send one request and decode the JSON response into out
+func do(t *testing.T, method string, url string, contentType string, body []byte, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// encodeItems(*testing.T, *pprq.Scheme, ...uint64): encrypt the values as a stream of items
+func encodeItems(t *testing.T, s *pprq.Scheme, values ...uint64) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := pprq.NewEncoder(&buf, s.Params())
+	for _, v := range values {
+		item, err := s.IndexItemEnc(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(&item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestServerEndToEnd(t *testing.T) {
+	ts := httptest.NewServer(NewServer())
+	defer ts.Close()
+	s := newScheme(t, pprq.DefaultParams())
+
+	// the owner uploads the initial index
+	idx, err := s.IndexEnc([]uint64{16548, 26496, 10000, 36014, 20000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := idx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var put IndexResponse
+	if status := do(t, http.MethodPut, ts.URL+"/indexes/temp", "application/octet-stream", data, &put); status != http.StatusOK || put.Items != 5 {
+		t.Fatalf("PUT index: status %d, items %d", status, put.Items)
+	}
+
+	// a device appends two readings
+	var appended AppendResponse
+	if status := do(t, http.MethodPost, ts.URL+"/indexes/temp/items", "application/octet-stream", encodeItems(t, s, 15000, 40000), &appended); status != http.StatusOK {
+		t.Fatalf("POST items: status %d", status)
+	}
+	if want := []pprq.ItemID{5, 6}; !reflect.DeepEqual(appended.IDs, want) || appended.Items != 7 {
+		t.Fatalf("POST items: got %+v, want ids %v", appended, want)
+	}
+
+	// the owner queries [10000, 20000] in the binary and in the JSON form
+	token, err := s.QueryEncRanges(pprq.Range{Lower: 10000, Upper: 20000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pprq.ItemID{0, 2, 4, 5}
+	bin, err := token.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res SearchResponse
+	if status := do(t, http.MethodPost, ts.URL+"/indexes/temp/search", "application/octet-stream", bin, &res); status != http.StatusOK || !reflect.DeepEqual(res.IDs, want) {
+		t.Fatalf("binary search: status %d, got %v, want %v", status, res.IDs, want)
+	}
+	if res.Stats.Items != 7 || res.Stats.Matches != 4 || res.Stats.PRF == 0 {
+		t.Fatalf("binary search: stats %+v", res.Stats)
+	}
+	js, err := json.Marshal(SearchRequest{Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = SearchResponse{}
+	if status := do(t, http.MethodPost, ts.URL+"/indexes/temp/search", "application/json", js, &res); status != http.StatusOK || !reflect.DeepEqual(res.IDs, want) {
+		t.Fatalf("JSON search: status %d, got %v, want %v", status, res.IDs, want)
+	}
+
+	// only the owner can open the returned payloads
+	var values []uint64
+	for _, sealed := range res.Payloads {
+		payload, err := s.OpenPayload(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, payload.Value)
+	}
+	if want := []uint64{16548, 10000, 20000, 15000}; !reflect.DeepEqual(values, want) {
+		t.Fatalf("payloads: got %v, want %v", values, want)
+	}
+
+	// the downloaded index is the same as the one on the server
+	resp, err := http.Get(ts.URL + "/indexes/temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err := pprq.ReadIndex(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := downloaded.Match(token); err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("downloaded index: got %v (%v), want %v", got, err, want)
+	}
+}
+
+func TestServerIdempotentAppend(t *testing.T) {
+	ts := httptest.NewServer(NewServer())
+	defer ts.Close()
+	s := newScheme(t, pprq.DefaultParams())
+	batch := encodeItems(t, s, 1, 2, 3)
+
+	// post(string, string): append the batch with the idempotency key (none if empty)
+	post := func(name string, key string) AppendResponse {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/indexes/"+name+"/items", bytes.NewReader(batch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set(IdempotencyHeader, key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out AppendResponse
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST items: status %d", resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	first := post("temp", "batch-1")
+	if again := post("temp", "batch-1"); !reflect.DeepEqual(again, first) {
+		t.Errorf("repeated key: got %+v, want %+v", again, first)
+	}
+	if next := post("temp", "batch-2"); !reflect.DeepEqual(next.IDs, []pprq.ItemID{3, 4, 5}) {
+		t.Errorf("new key: got %+v", next)
+	}
+	if next := post("temp", ""); !reflect.DeepEqual(next.IDs, []pprq.ItemID{6, 7, 8}) {
+		t.Errorf("no key: got %+v", next)
+	}
+	if other := post("other", "batch-1"); !reflect.DeepEqual(other.IDs, []pprq.ItemID{0, 1, 2}) { // the keys are per index
+		t.Errorf("key of another index: got %+v", other)
+	}
+
+	// the keys are forgotten with their index
+	if status := do(t, http.MethodDelete, ts.URL+"/indexes/temp", "", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("DELETE index: status %d", status)
+	}
+	if again := post("temp", "batch-1"); again.Items != 3 {
+		t.Errorf("key of a deleted index: got %+v", again)
+	}
+}
+
+func TestServerConcurrentDeleteAppend(t *testing.T) {
+	server := NewServer()
+	s := newScheme(t, pprq.DefaultParams())
+	batch := encodeItems(t, s, 1, 2, 3)
+
+	// serve(string, string, string): serve one request with the idempotency key (none if empty) and return its status
+	serve := func(method string, target string, key string) int {
+		req := httptest.NewRequest(method, target, bytes.NewReader(batch))
+		if key != "" {
+			req.Header.Set(IdempotencyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// the deletes and the idempotent appends of one index take the locks of the server and of the batches concurrently
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if status := serve(http.MethodPost, "/indexes/a/items", fmt.Sprintf("batch-%d", i)); status != http.StatusOK {
+				t.Errorf("POST items: status %d", status)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if status := serve(http.MethodDelete, "/indexes/a", ""); status != http.StatusNoContent && status != http.StatusNotFound {
+				t.Errorf("DELETE index: status %d", status)
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the deletes and the appends are deadlocked")
+	}
+
+	// an append in progress with one key does not hold up the appends with other keys
+	slow := server.batchOf(batchKey{name: "a", key: "slow"})
+	slow.mu.Lock()
+	defer slow.mu.Unlock()
+	appended := make(chan int, 1)
+	go func() {
+		appended <- serve(http.MethodPost, "/indexes/b/items", "fast")
+	}()
+	select {
+	case status := <-appended:
+		if status != http.StatusOK {
+			t.Errorf("POST items with another key: status %d", status)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the append with another key waits for the append in progress")
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	ts := httptest.NewServer(NewServer())
+	defer ts.Close()
+	s := newScheme(t, pprq.DefaultParams())
+	other := newScheme(t, pprq.Params{BlockSize: 4, SubIndexSize: 15, DomainBits: 16})
+
+	if status := do(t, http.MethodPost, ts.URL+"/indexes/temp/items", "", encodeItems(t, s, 1, 2), nil); status != http.StatusOK {
+		t.Fatalf("POST items: status %d", status)
+	}
+	token, err := s.QueryEncRanges(pprq.Range{Lower: 0, Upper: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, err := token.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := other.QueryEncRanges(pprq.Range{Lower: 0, Upper: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBin, err := otherToken.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        []byte
+		status      int
+	}{
+		{"unknown index", http.MethodPost, "/indexes/none/search", "", bin, http.StatusNotFound},
+		{"malformed token", http.MethodPost, "/indexes/temp/search", "", bin[:len(bin)-1], http.StatusBadRequest},
+		{"malformed JSON", http.MethodPost, "/indexes/temp/search", "application/json", []byte(`{"token": "!!"}`), http.StatusBadRequest},
+		{"missing token", http.MethodPost, "/indexes/temp/search", "application/json", []byte(`{}`), http.StatusBadRequest},
+		{"token of other parameters", http.MethodPost, "/indexes/temp/search", "", otherBin, http.StatusConflict},
+		{"items of other parameters", http.MethodPost, "/indexes/temp/items", "", encodeItems(t, other, 1), http.StatusConflict},
+		{"malformed items", http.MethodPost, "/indexes/temp/items", "", []byte("PPRQ"), http.StatusBadRequest},
+		{"malformed index", http.MethodPut, "/indexes/temp", "", []byte("not an index"), http.StatusBadRequest},
+		{"delete unknown index", http.MethodDelete, "/indexes/none", "", nil, http.StatusNotFound},
+		{"delete index", http.MethodDelete, "/indexes/temp", "", nil, http.StatusNoContent},
+		{"search deleted index", http.MethodPost, "/indexes/temp/search", "", bin, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if status := do(t, tt.method, ts.URL+tt.path, tt.contentType, tt.body, nil); status != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.status)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	for _, c := range []struct {
+		err    error
+		status int
+	}{
+		{errNotFound, http.StatusNotFound},
+		{fmt.Errorf("item 3: %w", pprq.ErrNoSuchItem), http.StatusNotFound},
+		{pprq.ErrParamMismatch, http.StatusConflict},
+		{pprq.ErrConflict, http.StatusPreconditionFailed},
+		{pprq.ErrMalformedIndex, http.StatusBadRequest},
+		{pprq.ErrMalformedToken, http.StatusBadRequest},
+		{fmt.Errorf("%w: block size 0", pprq.ErrInvalidParams), http.StatusBadRequest},
+		{fmt.Errorf("%w: nil key", pprq.ErrInvalidKey), http.StatusBadRequest},
+		{errBadRequest, http.StatusBadRequest},
+		{&http.MaxBytesError{Limit: 1}, http.StatusRequestEntityTooLarge},
+		{context.DeadlineExceeded, http.StatusServiceUnavailable},
+		{pprq.ErrRandomness, http.StatusInternalServerError},
+	} {
+		w := httptest.NewRecorder()
+		writeError(w, c.err)
+		var res errorResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != c.status || res.Error != c.err.Error() {
+			t.Errorf("%v: status %d, error %q, want %d", c.err, w.Code, res.Error, c.status)
+		}
+	}
+}
+
+func TestServerKeyRotation(t *testing.T) {
+	ts := httptest.NewServer(NewServer())
+	defer ts.Close()
+	from := newScheme(t, pprq.DefaultParams())
+	to := newScheme(t, pprq.DefaultParams())
+
+	idx, err := from.IndexEnc([]uint64{16548, 26496, 10000, 36014, 20000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := idx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := do(t, http.MethodPut, ts.URL+"/indexes/temp", "", data, nil); status != http.StatusOK {
+		t.Fatalf("PUT index: status %d", status)
+	}
+	fromToken, err := from.QueryEncRanges(pprq.Range{Lower: 10000, Upper: 20000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	toToken, err := to.QueryEncRanges(pprq.Range{Lower: 10000, Upper: 20000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	both, err := pprq.MarshalTokens(fromToken, toToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := func(body []byte) []pprq.ItemID {
+		t.Helper()
+		var res SearchResponse
+		if status := do(t, http.MethodPost, ts.URL+"/indexes/temp/search", "", body, &res); status != http.StatusOK {
+			t.Fatalf("search: status %d", status)
+		}
+		return res.IDs
+	}
+
+	// the index is re-encrypted in batches of two items, and it is searched by the tokens of both keys in between
+	rotation, err := pprq.NewRotation(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		ids, revisions, items, err := rotation.Next(idx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) == 0 {
+			break
+		}
+		var buf bytes.Buffer
+		enc := pprq.NewEncoder(&buf, idx.Params())
+		for i := range items {
+			if err := enc.Encode(&items[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		query, revs := ids[0].String(), revisions[0].String()
+		for i := 1; i < len(ids); i++ {
+			query += "," + ids[i].String()
+			revs += "," + revisions[i].String()
+		}
+		if status := do(t, http.MethodPut, ts.URL+"/indexes/temp/items?ids="+query+"&revs="+revs, "", buf.Bytes(), nil); status != http.StatusOK {
+			t.Fatalf("PUT items: status %d", status)
+		}
+		// the same batch again: the items have been replaced since their revisions
+		if status := do(t, http.MethodPut, ts.URL+"/indexes/temp/items?ids="+query+"&revs="+revs, "", buf.Bytes(), nil); status != http.StatusPreconditionFailed {
+			t.Fatalf("PUT items again: status %d", status)
+		}
+		if got, want := search(both), []pprq.ItemID{0, 2, 4}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("search after batch %v: got %v, want %v", ids, got, want)
+		}
+	}
+
+	// the retiring key matches nothing after the rotation, and the JSON form carries both tokens
+	if got := search(mustMarshal(t, fromToken)); len(got) != 0 {
+		t.Fatalf("search with the retiring key: got %v", got)
+	}
+	js, err := json.Marshal(SearchRequest{Tokens: []*pprq.QueryToken{fromToken, toToken}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res SearchResponse
+	if status := do(t, http.MethodPost, ts.URL+"/indexes/temp/search", "application/json", js, &res); status != http.StatusOK || !reflect.DeepEqual(res.IDs, []pprq.ItemID{0, 2, 4}) {
+		t.Fatalf("JSON search: status %d, got %v", status, res.IDs)
+	}
+	for _, sealed := range res.Payloads { // the payloads are re-sealed with the new key
+		if _, err := to.OpenPayload(sealed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the replaced items must exist, and the tokens of one key return the union of their ranges
+	if status := do(t, http.MethodPut, ts.URL+"/indexes/temp/items?ids=5&revs=0", "", encodeItems(t, to, 1), nil); status != http.StatusNotFound {
+		t.Fatalf("PUT items out of the index: status %d", status)
+	}
+	for _, query := range []string{"ids=x&revs=0", "ids=0&revs=x", "ids=0", "ids=0,1&revs=0", "ids=0,1&revs=0,0"} { // the last one has 2 IDs for 1 item
+		if status := do(t, http.MethodPut, ts.URL+"/indexes/temp/items?"+query, "", encodeItems(t, to, 1), nil); status != http.StatusBadRequest {
+			t.Fatalf("PUT items?%s: status %d", query, status)
+		}
+	}
+	other, err := to.QueryEncRanges(pprq.Range{Lower: 26000, Upper: 27000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	union, err := pprq.MarshalTokens(toToken, other, toToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = SearchResponse{}
+	if status := do(t, http.MethodPost, ts.URL+"/indexes/temp/search", "", union, &res); status != http.StatusOK || !reflect.DeepEqual(res.IDs, []pprq.ItemID{0, 1, 2, 4}) {
+		t.Fatalf("search with three tokens of one key: status %d, got %v", status, res.IDs)
+	}
+}
+
+// mustMarshal(*testing.T, *pprq.QueryToken): encode the token in the binary form
+func mustMarshal(t *testing.T, q *pprq.QueryToken) []byte {
+	t.Helper()
+	b, err := q.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestServerUpdateDelete(t *testing.T) {
+	ts := httptest.NewServer(NewServer())
+	defer ts.Close()
+	s := newScheme(t, pprq.DefaultParams())
+	token, err := s.QueryEncRanges(pprq.Range{Lower: 10000, Upper: 20000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := mustMarshal(t, token)
+	search := func() []pprq.ItemID {
+		t.Helper()
+		var res SearchResponse
+		if status := do(t, http.MethodPost, ts.URL+"/indexes/temp/search", "", bin, &res); status != http.StatusOK {
+			t.Fatalf("search: status %d", status)
+		}
+		return res.IDs
+	}
+
+	var appended AppendResponse
+	if status := do(t, http.MethodPost, ts.URL+"/indexes/temp/items", "", encodeItems(t, s, 15000, 16000, 30000, 17000), &appended); status != http.StatusOK {
+		t.Fatalf("POST items: status %d", status)
+	}
+	item, err := s.IndexItemEnc(12000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := item.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the IDs stay the same after the updates, the deletions and the compaction (deleting 0 and 3 leaves one live item of four)
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   []byte
+		status int
+		want   []pprq.ItemID
+	}{
+		{"delete 1", http.MethodDelete, "/indexes/temp/items/1", nil, http.StatusNoContent, []pprq.ItemID{0, 3}},
+		{"update 2", http.MethodPut, "/indexes/temp/items/2", updated, http.StatusOK, []pprq.ItemID{0, 2, 3}},
+		{"delete 1 again", http.MethodDelete, "/indexes/temp/items/1", nil, http.StatusNotFound, []pprq.ItemID{0, 2, 3}},
+		{"update deleted", http.MethodPut, "/indexes/temp/items/1", updated, http.StatusNotFound, []pprq.ItemID{0, 2, 3}},
+		{"delete 0", http.MethodDelete, "/indexes/temp/items/0", nil, http.StatusNoContent, []pprq.ItemID{2, 3}},
+		{"delete 3", http.MethodDelete, "/indexes/temp/items/3", nil, http.StatusNoContent, []pprq.ItemID{2}},
+		{"append", http.MethodPost, "/indexes/temp/items", encodeItems(t, s, 18000), http.StatusOK, []pprq.ItemID{2, 4}},
+		{"bad ID", http.MethodDelete, "/indexes/temp/items/x", nil, http.StatusBadRequest, []pprq.ItemID{2, 4}},
+		{"malformed item", http.MethodPut, "/indexes/temp/items/2", updated[:10], http.StatusBadRequest, []pprq.ItemID{2, 4}},
+	}
+	for _, step := range steps {
+		if status := do(t, step.method, ts.URL+step.path, "", step.body, nil); status != step.status {
+			t.Fatalf("%s: status %d, want %d", step.name, status, step.status)
+		}
+		if got := search(); !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%s: got %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	// the IDs survive the download
+	resp, err := http.Get(ts.URL + "/indexes/temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err := pprq.ReadIndex(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := downloaded.IDs(); !reflect.DeepEqual(got, []pprq.ItemID{2, 4}) {
+		t.Fatalf("downloaded IDs: got %v", got)
+	}
+	if id, err := downloaded.Append(item); err != nil || id != 5 {
+		t.Fatalf("append to the downloaded index: got %v (%v), want 5", id, err)
+	}
+}
+
+func TestServerConcurrentSearch(t *testing.T) {
+	srv := NewServer()
+	srv.Workers = 4
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	s := newScheme(t, pprq.DefaultParams())
+	token, err := s.QueryEncRanges(pprq.Range{Lower: 10000, Upper: 20000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := mustMarshal(t, token)
+	values := make([]uint64, 600) // more than one chunk of the workers, half of them in the range
+	for i := range values {
+		values[i] = uint64(5000 + i%2*10000)
+	}
+	if status := do(t, http.MethodPost, ts.URL+"/indexes/temp/items", "", encodeItems(t, s, values...), nil); status != http.StatusOK {
+		t.Fatalf("POST items: status %d", status)
+	}
+
+	// the searches run while the batches of two items (one in the range) are appended
+	batch := encodeItems(t, s, 15000, 30000)
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 20; i++ {
+			resp, err := http.Post(ts.URL+"/indexes/temp/items", "application/octet-stream", bytes.NewReader(batch))
+			if err != nil {
+				done <- err
+				return
+			}
+			resp.Body.Close()
+		}
+		done <- nil
+	}()
+	for i := 0; i < 20; i++ {
+		var res SearchResponse
+		if status := do(t, http.MethodPost, ts.URL+"/indexes/temp/search", "", bin, &res); status != http.StatusOK {
+			t.Fatalf("search: status %d", status)
+		}
+		if n := len(res.IDs); n < 300 || n > 320 || len(res.Payloads) != n {
+			t.Fatalf("search: %d IDs and %d payloads", n, len(res.Payloads))
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Index("temp").Len(); got != 640 {
+		t.Fatalf("got %d items, want 640", got)
+	}
+
+	// a search which cannot finish in time fails
+	srv.SearchTimeout = time.Nanosecond
+	if status := do(t, http.MethodPost, ts.URL+"/indexes/temp/search", "", bin, nil); status != http.StatusServiceUnavailable {
+		t.Fatalf("search after the deadline: status %d", status)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+	hilbert.go - the Hilbert-curve mapping between the 2-D space and the 1-D space
+	By Hongcheng Xie at Department of Computer Science, City University of Hong Kong, Hong Kong SAR, China
+
+	Package hilbert maps the cells of a 2^order * 2^order grid to their distances along the Hilbert curve and back. The nearby cells tend to have nearby distances, so a 2-D area is covered by a few 1-D intervals which the range query of package pprq can search.
+	Curve is a Mapping of 2 dimensions, and the grids of more dimensions are mapped by HilbertND and ZOrder (see nd.go).
+	The code is based on Wikipedia (https://en.wikipedia.org/wiki/Hilbert_curve).
+*/
+package hilbert
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Curve: the Hilbert curve filling a 2^order * 2^order grid
+type Curve struct {
+	grid
+}
+
+// Point: one cell of the grid
+type Point struct {
+	X uint64 // the column of the cell
+	Y uint64 // the row of the cell
+}
+
+const MaxOrder int = 32 // the max order, i.e. the max order whose distances fit in 64 bits
+
+// the errors returned by the curve. they are wrapped with the details, so they should be checked with errors.Is
+var (
+	ErrInvalidOrder = errors.New("hilbert: invalid order")                 // the order is out of [1,MaxOrder]
+	ErrOutOfGrid    = errors.New("hilbert: point or distance out of grid") // the coordinate or the distance does not fit in the grid
+)
+
+// New(int): create the curve of a 2^order * 2^order grid
+func New(order int) (*Curve, error) {
+	if order < 1 || order > MaxOrder {
+		return nil, fmt.Errorf("%w: %d not in [1,%d]", ErrInvalidOrder, order, MaxOrder)
+	}
+	return &Curve{grid: grid{dims: 2, order: order}}, nil
+}
+
+// rot(uint64, *uint64, *uint64, uint64, uint64): rotate/flip a quadrant
+func rot(n uint64, x *uint64, y *uint64, rx uint64, ry uint64) {
+	if ry == 0 {
+		if rx == 1 {
+			*x = n - 1 - *x
+			*y = n - 1 - *y
+		}
+		*x, *y = *y, *x
+	}
+}
+
+// bit(bool): convert the flag to 0 or 1
+func bit(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// XY2D(uint64, uint64): convert (x,y) to its distance d along the curve
+func (c *Curve) XY2D(x uint64, y uint64) (uint64, error) {
+	if x > c.Side() || y > c.Side() {
+		return 0, fmt.Errorf("%w: (%d,%d) not in [0,%d]^2", ErrOutOfGrid, x, y, c.Side())
+	}
+	var d uint64
+	n := uint64(1) << c.order // the number of cells in one row
+	for s := n / 2; s > 0; s /= 2 {
+		rx := bit(x&s > 0)
+		ry := bit(y&s > 0)
+		d += s * s * ((3 * rx) ^ ry)
+		rot(n, &x, &y, rx, ry)
+	}
+	return d, nil
+}
+
+// D2XY(uint64): convert the distance d along the curve to (x,y)
+func (c *Curve) D2XY(d uint64) (uint64, uint64, error) {
+	if d > c.MaxDistance() {
+		return 0, 0, fmt.Errorf("%w: distance %d not in [0,%d]", ErrOutOfGrid, d, c.MaxDistance())
+	}
+	var x, y uint64
+	t := d
+	for s := uint64(1); s <= c.Side(); s *= 2 {
+		rx := 1 & (t / 2)
+		ry := 1 & (t ^ rx)
+		rot(s, &x, &y, rx, ry)
+		x += s * rx
+		y += s * ry
+		t /= 4
+	}
+	return x, y, nil
+}
+
+// EncodePoint(Point): convert the point to its distance along the curve (see XY2D)
+func (c *Curve) EncodePoint(p Point) (uint64, error) {
+	return c.XY2D(p.X, p.Y)
+}
+
+// DecodePoint(uint64): convert the distance along the curve to the point (see D2XY)
+func (c *Curve) DecodePoint(d uint64) (Point, error) {
+	x, y, err := c.D2XY(d)
+	return Point{X: x, Y: y}, err
+}
+
+// Encode([]uint64): convert the cell (x,y) to its distance along the curve (see XY2D)
+func (c *Curve) Encode(coords []uint64) (uint64, error) {
+	if err := c.checkCell(coords); err != nil {
+		return 0, err
+	}
+	return c.XY2D(coords[0], coords[1])
+}
+
+// Decode(uint64): convert the distance along the curve to the cell (x,y) (see D2XY)
+func (c *Curve) Decode(d uint64) ([]uint64, error) {
+	if err := c.checkDistance(d); err != nil {
+		return nil, err
+	}
+	return c.decode(d), nil
+}
+
+// decode(uint64): Decode without the check
+func (c *Curve) decode(d uint64) []uint64 {
+	x, y, _ := c.D2XY(d)
+	return []uint64{x, y}
+}
+
+// Intervals(Box, int): decompose the box of 2 coordinates (e.g. Rect.Box) into the fewest intervals along the curve which cover exactly its cells, in ascending order. if there are more than maxIntervals of them (maxIntervals > 0), the intervals separated by the smallest gaps are merged until maxIntervals remain, so the result also covers the cells in those gaps (the false positives of the query, see Length). a capped decomposition stops splitting the cubes at the edge of the box once its budget is spent, so its cost is bounded by maxIntervals rather than by the size of the box
+func (c *Curve) Intervals(b Box, maxIntervals int) ([]Interval, error) {
+	return c.intervals(b, maxIntervals, c.decode)
+}
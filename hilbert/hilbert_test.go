@@ -0,0 +1,383 @@
+package hilbert
+
+import (
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOrderOne(t *testing.T) {
+	c, err := New(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the curve of a 2*2 grid visits (0,0), (0,1), (1,1), (1,0)
+	for d, want := range []Point{{0, 0}, {0, 1}, {1, 1}, {1, 0}} {
+		got, err := c.DecodePoint(uint64(d))
+		if err != nil || got != want {
+			t.Errorf("D2XY(%d): got %v, %v, want %v", d, got, err, want)
+		}
+	}
+}
+
+func TestRoundTripAndAdjacency(t *testing.T) {
+	for order := 1; order <= 6; order++ {
+		c, err := New(order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var prev Point
+		seen := make(map[Point]bool)
+		for d := uint64(0); d <= c.MaxDistance(); d++ {
+			pt, err := c.DecodePoint(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seen[pt] {
+				t.Fatalf("order %d: %v visited twice", order, pt)
+			}
+			seen[pt] = true
+			if back, err := c.EncodePoint(pt); err != nil || back != d {
+				t.Fatalf("order %d: XY2D(%v) = %d, %v, want %d", order, pt, back, err, d)
+			}
+			// the consecutive cells along the curve are neighbours
+			if d > 0 && absDiff(pt.X, prev.X)+absDiff(pt.Y, prev.Y) != 1 {
+				t.Fatalf("order %d: %v and %v are not neighbours", order, prev, pt)
+			}
+			prev = pt
+		}
+	}
+}
+
+// absDiff(uint64, uint64): |a-b|
+func absDiff(a uint64, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestLargeOrders(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, order := range []int{16, 31, MaxOrder} {
+		c, err := New(order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		corners := []Point{{0, 0}, {0, c.Side()}, {c.Side(), c.Side()}, {c.Side(), 0}}
+		for i := 0; i < 1000; i++ {
+			pt := Point{r.Uint64() & c.Side(), r.Uint64() & c.Side()}
+			if i < len(corners) {
+				pt = corners[i]
+			}
+			d, err := c.EncodePoint(pt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if back, err := c.DecodePoint(d); err != nil || back != pt {
+				t.Fatalf("order %d: D2XY(XY2D(%v)) = %v, %v", order, pt, back, err)
+			}
+		}
+		// the curve starts at (0,0) and ends at (side,0)
+		if d, _ := c.EncodePoint(Point{c.Side(), 0}); d != c.MaxDistance() {
+			t.Errorf("order %d: XY2D(side,0) = %d, want %d", order, d, c.MaxDistance())
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, order := range []int{0, -1, MaxOrder + 1} {
+		if _, err := New(order); !errors.Is(err, ErrInvalidOrder) {
+			t.Errorf("New(%d): got %v, want ErrInvalidOrder", order, err)
+		}
+	}
+	c, err := New(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.XY2D(16, 0); !errors.Is(err, ErrOutOfGrid) {
+		t.Errorf("XY2D(16,0): got %v, want ErrOutOfGrid", err)
+	}
+	if _, _, err := c.D2XY(256); !errors.Is(err, ErrOutOfGrid) {
+		t.Errorf("D2XY(256): got %v, want ErrOutOfGrid", err)
+	}
+}
+
+// checkCapped(*testing.T, Mapping, Box, int): decompose the box with the cap and check that the intervals are few and ascending and cover the corners and random cells of the box in a bounded time
+func checkCapped(t *testing.T, m Mapping, b Box, maxIntervals int) {
+	t.Helper()
+	start := time.Now()
+	intervals, err := m.Intervals(b, maxIntervals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("%T %v: capped decomposition took %v", m, b, elapsed)
+	}
+	if len(intervals) == 0 || len(intervals) > maxIntervals {
+		t.Fatalf("%T %v: %d intervals, want 1..%d", m, b, len(intervals), maxIntervals)
+	}
+	for j := 1; j < len(intervals); j++ {
+		if intervals[j].Lower <= intervals[j-1].Upper+1 {
+			t.Fatalf("%T %v: intervals %v and %v are not separated", m, b, intervals[j-1], intervals[j])
+		}
+	}
+	r := rand.New(rand.NewPCG(11, 12))
+	for i := 0; i < 1000; i++ {
+		cell := make([]uint64, m.Dims())
+		for j := range cell {
+			switch i {
+			case 0:
+				cell[j] = b.Min[j]
+			case 1:
+				cell[j] = b.Max[j]
+			default:
+				cell[j] = b.Min[j] + r.Uint64N(b.Max[j]-b.Min[j]+1)
+			}
+		}
+		d, err := m.Encode(cell)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, _ := slices.BinarySearchFunc(intervals, d, func(iv Interval, d uint64) int {
+			if iv.Upper < d {
+				return -1
+			}
+			if iv.Lower > d {
+				return 1
+			}
+			return 0
+		})
+		if k == len(intervals) || intervals[k].Lower > d {
+			t.Fatalf("%T %v: %v (distance %d) not covered by %v", m, b, cell, d, intervals)
+		}
+	}
+}
+
+func TestCappedLargeGrid(t *testing.T) {
+	// the exact decomposition of such a box has about 2^{(dims-1)*order} intervals, but a capped one only refines its budget
+	for _, size := range [][2]int{{2, 32}, {3, 21}, {4, 16}, {MaxDims, 8}} {
+		dims, order := size[0], size[1]
+		for _, m := range mappings(t, dims, order) {
+			side := m.Side()
+			b := Box{Min: make([]uint64, dims), Max: make([]uint64, dims)}
+			for j := 0; j < dims; j++ {
+				b.Min[j], b.Max[j] = side/uint64(7+j)+uint64(j), side-side/uint64(5+j)-3
+			}
+			for _, maxIntervals := range []int{1, 16, 256} {
+				checkCapped(t, m, b, maxIntervals)
+			}
+		}
+	}
+	c, err := New(MaxOrder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCapped(t, c, Rect{Min: Point{X: 1 << 20, Y: 3}, Max: Point{X: 1<<31 + 7, Y: 1<<30 + 5}}.Box(), 16)
+}
+
+func TestRectBox(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	c, err := New(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		x1, x2 := r.Uint64N(c.Side()+1), r.Uint64N(c.Side()+1)
+		y1, y2 := r.Uint64N(c.Side()+1), r.Uint64N(c.Side()+1)
+		rect := Rect{Min: Point{X: min(x1, x2), Y: min(y1, y2)}, Max: Point{X: max(x1, x2), Y: max(y1, y2)}}
+		b := rect.Box()
+		if b.Volume() != rect.Area() {
+			t.Fatalf("%v: box of %d cells, want %d", rect, b.Volume(), rect.Area())
+		}
+		pt := Point{X: r.Uint64N(c.Side() + 1), Y: r.Uint64N(c.Side() + 1)}
+		if b.Contains([]uint64{pt.X, pt.Y}) != rect.Contains(pt) {
+			t.Fatalf("%v: the box and the rectangle disagree on %v", rect, pt)
+		}
+	}
+	if _, err := c.Intervals(Rect{Min: Point{X: 2}, Max: Point{X: 1}}.Box(), 0); !errors.Is(err, ErrOutOfGrid) {
+		t.Errorf("empty rectangle: got %v, want ErrOutOfGrid", err)
+	}
+}
+
+// mappings(*testing.T, int, int): create the mappings of the grid, i.e. the N-D Hilbert curve, the Z-order curve and, for 2 dimensions, the 2-D Hilbert curve
+func mappings(t *testing.T, dims int, order int) []Mapping {
+	t.Helper()
+	h, err := NewHilbertND(dims, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := NewZOrder(dims, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dims != 2 {
+		return []Mapping{h, z}
+	}
+	c, err := New(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []Mapping{h, z, c}
+}
+
+func TestNDRoundTrip(t *testing.T) {
+	for _, size := range [][2]int{{1, 4}, {2, 3}, {3, 1}, {3, 3}, {4, 2}} {
+		dims, order := size[0], size[1]
+		for _, m := range mappings(t, dims, order) {
+			var prev []uint64
+			seen := make(map[[MaxDims]uint64]bool)
+			for d := uint64(0); d <= m.MaxDistance(); d++ {
+				cell, err := m.Decode(d)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var key [MaxDims]uint64
+				copy(key[:], cell)
+				if seen[key] {
+					t.Fatalf("%T %v: %v visited twice", m, size, cell)
+				}
+				seen[key] = true
+				if back, err := m.Encode(cell); err != nil || back != d {
+					t.Fatalf("%T %v: Encode(%v) = %d, %v, want %d", m, size, cell, back, err, d)
+				}
+				// the consecutive cells along the Hilbert curves are neighbours
+				if _, z := m.(*ZOrder); !z && d > 0 {
+					var dist uint64
+					for i := range cell {
+						dist += absDiff(cell[i], prev[i])
+					}
+					if dist != 1 {
+						t.Fatalf("%T %v: %v and %v are not neighbours", m, size, prev, cell)
+					}
+				}
+				prev = cell
+			}
+		}
+	}
+
+	// the Z-order curve interleaves the bits from the first coordinate
+	z, err := NewZOrder(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for d, want := range [][]uint64{{0, 0}, {0, 1}, {1, 0}, {1, 1}} {
+		if got, _ := z.Decode(uint64(d)); !slices.Equal(got, want) {
+			t.Errorf("Z-order %d: got %v, want %v", d, got, want)
+		}
+	}
+}
+
+func TestNDLargeGrids(t *testing.T) {
+	r := rand.New(rand.NewPCG(5, 6))
+	for _, size := range [][2]int{{1, 64}, {2, 32}, {3, 21}, {4, 16}, {MaxDims, 8}} {
+		dims, order := size[0], size[1]
+		for _, m := range mappings(t, dims, order) {
+			for i := 0; i < 500; i++ {
+				cell := make([]uint64, dims)
+				for j := range cell {
+					cell[j] = r.Uint64() & m.Side()
+					if i == 0 {
+						cell[j] = m.Side()
+					}
+				}
+				d, err := m.Encode(cell)
+				if err != nil || d > m.MaxDistance() {
+					t.Fatalf("%T %v: Encode(%v) = %d, %v", m, size, cell, d, err)
+				}
+				if back, err := m.Decode(d); err != nil || !slices.Equal(back, cell) {
+					t.Fatalf("%T %v: Decode(Encode(%v)) = %v, %v", m, size, cell, back, err)
+				}
+			}
+		}
+	}
+}
+
+func TestNDErrors(t *testing.T) {
+	for _, size := range [][2]int{{0, 4}, {MaxDims + 1, 4}, {3, 0}, {3, 22}, {1, 65}} {
+		if _, err := NewHilbertND(size[0], size[1]); !errors.Is(err, ErrInvalidDims) && !errors.Is(err, ErrInvalidOrder) {
+			t.Errorf("NewHilbertND%v: got %v", size, err)
+		}
+		if _, err := NewZOrder(size[0], size[1]); !errors.Is(err, ErrInvalidDims) && !errors.Is(err, ErrInvalidOrder) {
+			t.Errorf("NewZOrder%v: got %v", size, err)
+		}
+	}
+	for _, m := range mappings(t, 3, 4) {
+		if _, err := m.Encode([]uint64{1, 2}); !errors.Is(err, ErrOutOfGrid) {
+			t.Errorf("%T: 2 coordinates: got %v", m, err)
+		}
+		if _, err := m.Encode([]uint64{1, 16, 2}); !errors.Is(err, ErrOutOfGrid) {
+			t.Errorf("%T: coordinate 16: got %v", m, err)
+		}
+		if _, err := m.Decode(1 << 12); !errors.Is(err, ErrOutOfGrid) {
+			t.Errorf("%T: distance 2^12: got %v", m, err)
+		}
+		if _, err := m.Intervals(Box{Min: []uint64{0, 3, 0}, Max: []uint64{1, 2, 1}}, 0); !errors.Is(err, ErrOutOfGrid) {
+			t.Errorf("%T: empty box: got %v", m, err)
+		}
+	}
+}
+
+func TestBoxIntervals(t *testing.T) {
+	r := rand.New(rand.NewPCG(7, 8))
+	for _, size := range [][2]int{{1, 5}, {2, 5}, {3, 3}, {4, 2}} {
+		dims, order := size[0], size[1]
+		for _, m := range mappings(t, dims, order) {
+			for i := 0; i < 100; i++ {
+				b := Box{Min: make([]uint64, dims), Max: make([]uint64, dims)}
+				for j := 0; j < dims; j++ {
+					x1, x2 := r.Uint64N(m.Side()+1), r.Uint64N(m.Side()+1)
+					b.Min[j], b.Max[j] = min(x1, x2), max(x1, x2)
+				}
+				intervals, err := m.Intervals(b, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// the intervals are ascending and separated, and they cover exactly the cells of the box
+				for j := 1; j < len(intervals); j++ {
+					if intervals[j].Lower <= intervals[j-1].Upper+1 {
+						t.Fatalf("%T %v: intervals %v and %v are not separated", m, b, intervals[j-1], intervals[j])
+					}
+				}
+				if Length(intervals) != b.Volume() {
+					t.Fatalf("%T %v: %d cells covered, want %d", m, b, Length(intervals), b.Volume())
+				}
+				for _, iv := range intervals {
+					for d := iv.Lower; d <= iv.Upper; d++ {
+						if cell, _ := m.Decode(d); !b.Contains(cell) {
+							t.Fatalf("%T %v: %v covered", m, b, cell)
+						}
+					}
+				}
+
+				// a capped decomposition still covers the box, and it keeps the largest gap
+				capped, err := m.Intervals(b, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(capped) > 2 || capped[0].Lower != intervals[0].Lower || capped[len(capped)-1].Upper != intervals[len(intervals)-1].Upper {
+					t.Fatalf("%T %v: capped %v of %v", m, b, capped, intervals)
+				}
+				if len(intervals) > 2 {
+					largest := uint64(0)
+					for j := 1; j < len(intervals); j++ {
+						largest = max(largest, intervals[j].Lower-intervals[j-1].Upper-1)
+					}
+					if len(capped) != 2 || capped[1].Lower-capped[0].Upper-1 != largest {
+						t.Fatalf("%T %v: capped to %v from %v", m, b, capped, intervals)
+					}
+				}
+			}
+		}
+	}
+
+	// the whole grid of 64 bits is one interval
+	for _, m := range mappings(t, 2, 32) {
+		whole := Box{Min: []uint64{0, 0}, Max: []uint64{m.Side(), m.Side()}}
+		if intervals, err := m.Intervals(whole, 0); err != nil || len(intervals) != 1 || intervals[0] != (Interval{Lower: 0, Upper: m.MaxDistance()}) {
+			t.Errorf("%T: whole grid: got %v, %v", m, intervals, err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package hilbert
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+	The N-D mappings cover the grids of dims dimensions with 2^order cells on each side (e.g. (x, y, time) or (x, y, floor)), so the distances take dims*order bits, which must fit in 64 bits.
+	HilbertND is the Hilbert curve by the transpose algorithm of Skilling (J. Skilling, "Programming the Hilbert curve", AIP Conference Proceedings 707, 2004), and ZOrder is the Z-order (Morton) curve, which interleaves the bits of the coordinates. Both curves fill every aligned sub-cube of 2^level cells on each side with 2^{dims*level} consecutive distances, like the 2-D Curve, so a box is decomposed into the intervals by the same routine on every curve (see rect.go). The Hilbert curve needs fewer intervals for the same box, and the Z-order curve is cheaper to encode.
+*/
+
+// Mapping: the mapping between the cells of an N-D grid and their distances along a space-filling curve
+type Mapping interface {
+	Dims() int                                             // the number of dimensions
+	Order() int                                            // the number of bits of one coordinate
+	Side() uint64                                          // the max coordinate (i.e. 2^order-1)
+	MaxDistance() uint64                                   // the max distance along the curve (i.e. 2^{dims*order}-1)
+	Encode(coords []uint64) (uint64, error)                // convert the cell to its distance along the curve
+	Decode(d uint64) ([]uint64, error)                     // convert the distance along the curve to the cell
+	Intervals(b Box, maxIntervals int) ([]Interval, error) // decompose the box into the intervals along the curve (see Curve.Intervals)
+}
+
+// Box: an axis-aligned box of cells in an N-D grid, inclusive on both corners
+type Box struct {
+	Min []uint64 // the corner with the smallest coordinates
+	Max []uint64 // the corner with the largest coordinates
+}
+
+const MaxDims int = 8 // the max number of dimensions (a node of the decomposition has 2^dims children)
+
+var ErrInvalidDims = errors.New("hilbert: invalid number of dimensions") // the number of dimensions is out of [1,MaxDims]
+
+// grid: the size of an N-D grid, shared by the mappings
+type grid struct {
+	dims  int // the number of dimensions
+	order int // the number of bits of one coordinate
+}
+
+// newGrid(int, int): check the size of an N-D grid
+func newGrid(dims int, order int) (grid, error) {
+	if dims < 1 || dims > MaxDims {
+		return grid{}, fmt.Errorf("%w: %d not in [1,%d]", ErrInvalidDims, dims, MaxDims)
+	}
+	if order < 1 || dims*order > 64 {
+		return grid{}, fmt.Errorf("%w: %d not in [1,%d] for %d dimensions", ErrInvalidOrder, order, 64/dims, dims)
+	}
+	return grid{dims: dims, order: order}, nil
+}
+
+// Dims(): return the number of dimensions
+func (g grid) Dims() int {
+	return g.dims
+}
+
+// Order(): return the number of bits of one coordinate
+func (g grid) Order() int {
+	return g.order
+}
+
+// Side(): return the max coordinate (i.e. 2^order-1)
+func (g grid) Side() uint64 {
+	return ^uint64(0) >> (64 - g.order)
+}
+
+// MaxDistance(): return the max distance along the curve (i.e. 2^{dims*order}-1)
+func (g grid) MaxDistance() uint64 {
+	return ^uint64(0) >> (64 - g.dims*g.order)
+}
+
+// checkCell([]uint64): check whether the cell is in the grid
+func (g grid) checkCell(coords []uint64) error {
+	if len(coords) != g.dims {
+		return fmt.Errorf("%w: %d coordinates (want %d)", ErrOutOfGrid, len(coords), g.dims)
+	}
+	for _, x := range coords {
+		if x > g.Side() {
+			return fmt.Errorf("%w: %v not in [0,%d]^%d", ErrOutOfGrid, coords, g.Side(), g.dims)
+		}
+	}
+	return nil
+}
+
+// checkDistance(uint64): check whether the distance is on the curve
+func (g grid) checkDistance(d uint64) error {
+	if d > g.MaxDistance() {
+		return fmt.Errorf("%w: distance %d not in [0,%d]", ErrOutOfGrid, d, g.MaxDistance())
+	}
+	return nil
+}
+
+// interleave([]uint64): concatenate the bits of the coordinates from the highest ones, i.e. bit j of coordinate i is bit (j*dims + dims-1-i) of the result
+func (g grid) interleave(coords []uint64) uint64 {
+	var d uint64
+	for j := g.order - 1; j >= 0; j-- {
+		for _, x := range coords {
+			d = d<<1 | (x>>j)&1
+		}
+	}
+	return d
+}
+
+// deinterleave(uint64): the inverse of interleave
+func (g grid) deinterleave(d uint64) []uint64 {
+	coords := make([]uint64, g.dims)
+	for j := g.order - 1; j >= 0; j-- {
+		for i := range coords {
+			coords[i] |= (d >> (j*g.dims + g.dims - 1 - i) & 1) << j
+		}
+	}
+	return coords
+}
+
+// Volume(): return the number of cells in the box (it wraps around if the box holds 2^64 cells)
+func (b Box) Volume() uint64 {
+	v := uint64(1)
+	for i := range b.Min {
+		v *= b.Max[i] - b.Min[i] + 1
+	}
+	return v
+}
+
+// Contains([]uint64): whether the cell is in the box (e.g. to filter out the false positives of a capped decomposition)
+func (b Box) Contains(coords []uint64) bool {
+	if len(coords) != len(b.Min) {
+		return false
+	}
+	for i, x := range coords {
+		if x < b.Min[i] || x > b.Max[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// HilbertND: the Hilbert curve filling an N-D grid
+type HilbertND struct {
+	grid
+}
+
+// NewHilbertND(int, int): create the Hilbert curve of a grid of dims dimensions with 2^order cells on each side
+func NewHilbertND(dims int, order int) (*HilbertND, error) {
+	g, err := newGrid(dims, order)
+	if err != nil {
+		return nil, err
+	}
+	return &HilbertND{grid: g}, nil
+}
+
+// Encode([]uint64): convert the cell to its distance along the curve (the coordinates are transposed into the Hilbert index and interleaved)
+func (h *HilbertND) Encode(coords []uint64) (uint64, error) {
+	if err := h.checkCell(coords); err != nil {
+		return 0, err
+	}
+	x := append([]uint64(nil), coords...)
+	n := len(x)
+	m := uint64(1) << (h.order - 1)
+	for q := m; q > 1; q >>= 1 { // inverse undo
+		p := q - 1
+		for i := 0; i < n; i++ {
+			if x[i]&q != 0 {
+				x[0] ^= p
+			} else {
+				t := (x[0] ^ x[i]) & p
+				x[0] ^= t
+				x[i] ^= t
+			}
+		}
+	}
+	for i := 1; i < n; i++ { // Gray encode
+		x[i] ^= x[i-1]
+	}
+	var t uint64
+	for q := m; q > 1; q >>= 1 {
+		if x[n-1]&q != 0 {
+			t ^= q - 1
+		}
+	}
+	for i := range x {
+		x[i] ^= t
+	}
+	return h.interleave(x), nil
+}
+
+// Decode(uint64): convert the distance along the curve to the cell
+func (h *HilbertND) Decode(d uint64) ([]uint64, error) {
+	if err := h.checkDistance(d); err != nil {
+		return nil, err
+	}
+	return h.decode(d), nil
+}
+
+// decode(uint64): Decode without the check
+func (h *HilbertND) decode(d uint64) []uint64 {
+	x := h.deinterleave(d)
+	n := len(x)
+	t := x[n-1] >> 1 // Gray decode
+	for i := n - 1; i > 0; i-- {
+		x[i] ^= x[i-1]
+	}
+	x[0] ^= t
+	for q := uint64(2); q != 0 && q <= h.Side(); q <<= 1 { // undo excess work
+		p := q - 1
+		for i := n - 1; i >= 0; i-- {
+			if x[i]&q != 0 {
+				x[0] ^= p
+			} else {
+				t := (x[0] ^ x[i]) & p
+				x[0] ^= t
+				x[i] ^= t
+			}
+		}
+	}
+	return x
+}
+
+// Intervals(Box, int): decompose the box into the fewest intervals along the curve which cover exactly its cells, in ascending order, and merge them across the smallest gaps if there are more than maxIntervals (maxIntervals > 0, see Curve.Intervals)
+func (h *HilbertND) Intervals(b Box, maxIntervals int) ([]Interval, error) {
+	return h.intervals(b, maxIntervals, h.decode)
+}
+
+// ZOrder: the Z-order (Morton) curve filling an N-D grid
+type ZOrder struct {
+	grid
+}
+
+// NewZOrder(int, int): create the Z-order curve of a grid of dims dimensions with 2^order cells on each side
+func NewZOrder(dims int, order int) (*ZOrder, error) {
+	g, err := newGrid(dims, order)
+	if err != nil {
+		return nil, err
+	}
+	return &ZOrder{grid: g}, nil
+}
+
+// Encode([]uint64): convert the cell to its distance along the curve, i.e. the interleaved bits of the coordinates
+func (z *ZOrder) Encode(coords []uint64) (uint64, error) {
+	if err := z.checkCell(coords); err != nil {
+		return 0, err
+	}
+	return z.interleave(coords), nil
+}
+
+// Decode(uint64): convert the distance along the curve to the cell
+func (z *ZOrder) Decode(d uint64) ([]uint64, error) {
+	if err := z.checkDistance(d); err != nil {
+		return nil, err
+	}
+	return z.deinterleave(d), nil
+}
+
+// Intervals(Box, int): decompose the box into the fewest intervals along the curve which cover exactly its cells, in ascending order, and merge them across the smallest gaps if there are more than maxIntervals (maxIntervals > 0, see Curve.Intervals)
+func (z *ZOrder) Intervals(b Box, maxIntervals int) ([]Interval, error) {
+	return z.intervals(b, maxIntervals, z.deinterleave)
+}
@@ -0,0 +1,159 @@
+package hilbert
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Rect: an axis-aligned rectangle of cells, inclusive on both corners
+type Rect struct {
+	Min Point // the corner with the smallest coordinates
+	Max Point // the corner with the largest coordinates
+}
+
+// Interval: the distances [Lower,Upper] along the curve, inclusive on both bounds
+type Interval struct {
+	Lower uint64 // the first distance
+	Upper uint64 // the last distance
+}
+
+// Area(): return the number of cells in the rectangle
+func (r Rect) Area() uint64 {
+	return (r.Max.X - r.Min.X + 1) * (r.Max.Y - r.Min.Y + 1)
+}
+
+// Contains(Point): whether the cell is in the rectangle (e.g. to filter out the false positives of a capped decomposition)
+func (r Rect) Contains(p Point) bool {
+	return p.X >= r.Min.X && p.X <= r.Max.X && p.Y >= r.Min.Y && p.Y <= r.Max.Y
+}
+
+// Box(): return the rectangle as a box of 2 coordinates (e.g. for Curve.Intervals)
+func (r Rect) Box() Box {
+	return Box{Min: []uint64{r.Min.X, r.Min.Y}, Max: []uint64{r.Max.X, r.Max.Y}}
+}
+
+// Length([]Interval): return the number of cells covered by the intervals (they must not overlap)
+func Length(intervals []Interval) uint64 {
+	var n uint64
+	for _, iv := range intervals {
+		n += iv.Upper - iv.Lower + 1
+	}
+	return n
+}
+
+// piece: one part of a decomposition in progress, i.e. an interval inside the box, or the interval of a cube which is partly in the box
+type piece struct {
+	Interval
+	partial bool // whether the cube is partly in the box, so it is split by the next level
+	level   int  // the level of the partial cube, i.e. it has 2^level cells on each side
+}
+
+const coverBudget int = 1024 // the least number of pieces refined by a capped decomposition (see cover)
+
+// intervals(Box, int, func(uint64) []uint64): decompose the box into the intervals of the curve whose cells are given by decode (see Curve.Intervals). it is shared by the mappings, since every curve fills the aligned sub-cubes with consecutive distances. a capped decomposition refines at most max(coverBudget, 16*maxIntervals) pieces per level, so its cost does not grow with the perimeter of the box
+func (g grid) intervals(b Box, maxIntervals int, decode func(uint64) []uint64) ([]Interval, error) {
+	if len(b.Min) != g.dims || len(b.Max) != g.dims {
+		return nil, fmt.Errorf("%w: box of %d and %d coordinates (want %d)", ErrOutOfGrid, len(b.Min), len(b.Max), g.dims)
+	}
+	for i := range b.Min {
+		if b.Min[i] > b.Max[i] {
+			return nil, fmt.Errorf("%w: empty box %v", ErrOutOfGrid, b)
+		}
+		if b.Max[i] > g.Side() {
+			return nil, fmt.Errorf("%w: box %v not in [0,%d]^%d", ErrOutOfGrid, b, g.Side(), g.dims)
+		}
+	}
+	limit := 0 // no limit
+	if maxIntervals > 0 {
+		limit = max(coverBudget, 16*maxIntervals)
+	}
+	pieces := g.cover(b, limit, decode)
+	res := make([]Interval, 0, len(pieces))
+	for _, p := range pieces { // the partial cubes left by the budget are covered whole
+		if n := len(res); n > 0 && res[n-1].Upper+1 == p.Lower {
+			res[n-1].Upper = p.Upper
+		} else {
+			res = append(res, p.Interval)
+		}
+	}
+	if maxIntervals > 0 && len(res) > maxIntervals {
+		res = mergeGaps(res, maxIntervals)
+	}
+	return res, nil
+}
+
+// cover(Box, int, func(uint64) []uint64): decompose the box level by level from the whole grid. each level splits the cubes partly in the box into their sub-cubes, which are visited in the order of the curve, so the pieces stay ascending, until only the intervals inside the box remain. once limit pieces (limit > 0) are reached, the cubes not split yet are kept whole, so the pieces still cover the box (with the cells of those cubes as the false positives)
+func (g grid) cover(b Box, limit int, decode func(uint64) []uint64) []piece {
+	pieces := g.classify(nil, b, 0, g.order, decode)
+	for split := true; split; {
+		split = false
+		next := make([]piece, 0, len(pieces))
+		for i, p := range pieces {
+			if !p.partial {
+				next = appendPiece(next, p)
+				continue
+			}
+			if limit > 0 && len(next) >= limit { // the budget is spent
+				return append(next, pieces[i:]...)
+			}
+			sub := uint64(1) << (g.dims * (p.level - 1)) // the number of cells in one sub-cube
+			for k := uint64(0); k < 1<<g.dims; k++ {
+				next = g.classify(next, b, p.Lower+k*sub, p.level-1, decode)
+			}
+			split = true
+		}
+		pieces = next
+	}
+	return pieces
+}
+
+// classify([]piece, Box, uint64, int, func(uint64) []uint64): append the cube of 2^level cells on each side whose distances start at d to pieces as an interval inside the box or as a partial cube (nothing if it is disjoint from the box)
+func (g grid) classify(pieces []piece, b Box, d uint64, level int, decode func(uint64) []uint64) []piece {
+	corner := decode(d)
+	side := ^uint64(0) >> (64 - level) // the cube is [corner,corner+side] on each side, aligned to its size (the shift of 64 bits gives 0 for a single cell)
+	inside := true
+	for i, x := range corner {
+		x &^= side
+		if x > b.Max[i] || x+side < b.Min[i] { // disjoint
+			return pieces
+		}
+		if x < b.Min[i] || x+side > b.Max[i] {
+			inside = false
+		}
+	}
+	last := d + ^uint64(0)>>(64-g.dims*level) // the last distance in the cube
+	return appendPiece(pieces, piece{Interval: Interval{Lower: d, Upper: last}, partial: !inside, level: level})
+}
+
+// appendPiece([]piece, piece): append p to pieces, and merge it into the last piece if both are intervals inside the box and they are adjacent
+func appendPiece(pieces []piece, p piece) []piece {
+	if n := len(pieces); n > 0 && !p.partial && !pieces[n-1].partial && pieces[n-1].Upper+1 == p.Lower {
+		pieces[n-1].Upper = p.Upper
+		return pieces
+	}
+	return append(pieces, p)
+}
+
+// mergeGaps([]Interval, int): merge the ascending intervals across their smallest gaps until n intervals remain
+func mergeGaps(intervals []Interval, n int) []Interval {
+	kept := make([]int, len(intervals)-1) // the gaps, i.e. the positions of the intervals after them
+	for i := range kept {
+		kept[i] = i + 1
+	}
+	gap := func(i int) uint64 {
+		return intervals[i].Lower - intervals[i-1].Upper
+	}
+	sort.SliceStable(kept, func(a, b int) bool {
+		return gap(kept[a]) > gap(kept[b])
+	})
+	kept = kept[:n-1] // the n-1 largest gaps separate the merged intervals
+	sort.Ints(kept)
+
+	res := make([]Interval, 0, n)
+	start := 0
+	for _, i := range append(kept, len(intervals)) {
+		res = append(res, Interval{Lower: intervals[start].Lower, Upper: intervals[i-1].Upper})
+		start = i
+	}
+	return res
+}
@@ -0,0 +1,298 @@
+package iot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/JerryXie96/PPRQueryIoT/hilbert"
+	"github.com/JerryXie96/PPRQueryIoT/pprq"
+)
+
+// Config: the provisioned configuration of one IoT device. every field has a type supported by gomobile
+type Config struct {
+	Endpoint string // the base URL of the fog node (e.g. "http://fog.local:8080")
+	Index    string // the name of the index on the fog node
+	DeviceID string // the identity of the device, sealed in the payload of every reading
+
+	BlockSize    int // the parameters of the scheme (see pprq.Params)
+	SubIndexSize int
+	DomainBits   int
+	Encoding     int // pprq.Encoding
+	Scale        int
+	Hashing      int // pprq.Hashing
+
+	BatchSize        int // the number of buffered readings which triggers an upload (0: upload only on Flush)
+	MaxBuffered      int // the max number of buffered readings, beyond which the new readings are refused with ErrBufferFull (0: no limit)
+	MaxRetries       int // the number of retries of one upload
+	RetryDelayMillis int // the delay before the first retry, doubled after each retry
+	TimeoutMillis    int // the timeout of one upload request
+}
+
+// Client: encrypt the readings of one IoT device and upload them to the fog node in batches
+type Client struct {
+	params  pprq.Params        // the parameters of the scheme
+	scheme  *pprq.Scheme       // the scheme with the provisioned key
+	cfg     Config             // the configuration
+	url     string             // the URL of the append endpoint
+	http    *http.Client       // the HTTP client
+	pending []pprq.IndexCipher // the encrypted readings which have not been uploaded
+
+	batch    []byte // the encoded batch of the first batchLen pending readings, kept until the fog node acknowledges it (nil: no batch in flight)
+	batchLen int    // the number of pending readings in batch
+	batchKey string // the idempotency key of batch, so the fog node stores it once however many times it is sent
+}
+
+// Record: the float readings reported together (e.g. the temperature, the humidity and the battery level), in the order of the attributes. it is built with Add, since gomobile cannot bind a variadic or a slice of floats
+type Record struct {
+	readings []float64 // the readings in the order of the attributes
+}
+
+const IdempotencyHeader string = "Idempotency-Key" // the header which carries the key of a batch (the same as fog.IdempotencyHeader)
+
+// the errors returned by the client (the errors of the scheme, e.g. pprq.ErrValueOutOfDomain, are returned as they are)
+var (
+	ErrInvalidConfig = errors.New("iot: invalid configuration") // the configuration or the key cannot be used
+	ErrUploadFailed  = errors.New("iot: upload failed")         // the fog node cannot be reached, or it fails, after all the retries. the batch is kept and sent again by the next Flush
+	ErrBatchRejected = errors.New("iot: batch rejected")        // the fog node rejected the batch for good (e.g. 400), so its readings are dropped
+	ErrUploadPending = errors.New("iot: upload pending")        // the reading is buffered, but the upload which it triggered failed (the error of Flush is wrapped). the reading must not be added again
+	ErrBufferFull    = errors.New("iot: buffer full")           // MaxBuffered readings wait for the upload, so the reading is not buffered
+)
+
+// NewConfig(string, string): create a configuration with the default parameters (the 2-bit version) and the default upload policy
+func NewConfig(endpoint string, index string) *Config {
+	p := pprq.DefaultParams()
+	return &Config{
+		Endpoint:         endpoint,
+		Index:            index,
+		BlockSize:        p.BlockSize,
+		SubIndexSize:     p.SubIndexSize,
+		DomainBits:       p.DomainBits,
+		Encoding:         int(p.Encoding),
+		Scale:            p.Scale,
+		Hashing:          int(p.Hashing),
+		BatchSize:        32,
+		MaxBuffered:      4096,
+		MaxRetries:       3,
+		RetryDelayMillis: 500,
+		TimeoutMillis:    10000,
+	}
+}
+
+// NewRecord(): create an empty record
+func NewRecord() *Record {
+	return &Record{}
+}
+
+// Add(float64): append the reading of the next attribute
+func (r *Record) Add(f float64) {
+	r.readings = append(r.readings, f)
+}
+
+// Len(): return the number of readings in the record
+func (r *Record) Len() int {
+	return len(r.readings)
+}
+
+// NewClient(*Config, []byte): create a client with the provisioned key (the raw form exported by pprq.Key.MarshalBinary)
+func NewClient(cfg *Config, key []byte) (*Client, error) {
+	var k pprq.Key
+	if err := k.UnmarshalBinary(key); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	p := pprq.Params{
+		BlockSize:    cfg.BlockSize,
+		SubIndexSize: cfg.SubIndexSize,
+		DomainBits:   cfg.DomainBits,
+		Encoding:     pprq.Encoding(cfg.Encoding),
+		Scale:        cfg.Scale,
+		Hashing:      pprq.Hashing(cfg.Hashing),
+	}
+	scheme, err := pprq.NewSchemeWithKey(&k, p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if cfg.Index == "" {
+		return nil, fmt.Errorf("%w: empty index name", ErrInvalidConfig)
+	}
+	base, err := url.Parse(cfg.Endpoint)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("%w: bad endpoint %q", ErrInvalidConfig, cfg.Endpoint)
+	}
+	return &Client{
+		params: p,
+		scheme: scheme,
+		cfg:    *cfg,
+		url:    base.JoinPath("indexes", cfg.Index, "items").String(),
+		http:   &http.Client{Timeout: time.Duration(cfg.TimeoutMillis) * time.Millisecond},
+	}, nil
+}
+
+// seal(uint64, error): encrypt one encoded reading with the device ID and the current time in its payload
+func (c *Client) seal(v uint64, err error) (pprq.IndexCipher, error) {
+	if err != nil {
+		return pprq.IndexCipher{}, err
+	}
+	return c.scheme.IndexItemEncPayload(pprq.Payload{Value: v, DeviceID: c.cfg.DeviceID, Timestamp: time.Now().UnixMilli()})
+}
+
+// Encrypt(int64): encrypt one integer reading and return the encoded index item
+func (c *Client) Encrypt(v int64) ([]byte, error) {
+	item, err := c.seal(c.params.EncodeInt(v))
+	if err != nil {
+		return nil, err
+	}
+	return item.MarshalBinary()
+}
+
+// EncryptFloat(float64): encrypt one float reading and return the encoded index item
+func (c *Client) EncryptFloat(f float64) ([]byte, error) {
+	item, err := c.seal(c.params.EncodeFloat(f))
+	if err != nil {
+		return nil, err
+	}
+	return item.MarshalBinary()
+}
+
+// Add(int64): encrypt one integer reading and buffer it. the buffer is uploaded when it reaches BatchSize, and a failure of that upload is returned as ErrUploadPending
+func (c *Client) Add(v int64) error {
+	item, err := c.seal(c.params.EncodeInt(v))
+	if err != nil {
+		return err
+	}
+	return c.push(item)
+}
+
+// AddFloat(float64): encrypt one float reading and buffer it. the buffer is uploaded when it reaches BatchSize, and a failure of that upload is returned as ErrUploadPending
+func (c *Client) AddFloat(f float64) error {
+	item, err := c.seal(c.params.EncodeFloat(f))
+	if err != nil {
+		return err
+	}
+	return c.push(item)
+}
+
+// AddRecord(*Record): encrypt the readings of r as the attributes of one record in their order (see pprq.Scheme.QueryEncConjunction) and buffer it. the buffer is uploaded when it reaches BatchSize, and a failure of that upload is returned as ErrUploadPending
+func (c *Client) AddRecord(r *Record) error {
+	if r == nil || r.Len() == 0 {
+		return fmt.Errorf("%w: record without reading", pprq.ErrInvalidParams)
+	}
+	values := make([]uint64, r.Len())
+	for a, f := range r.readings {
+		v, err := c.params.EncodeFloat(f)
+		if err != nil {
+			return err
+		}
+		values[a] = v
+	}
+	item, err := c.scheme.IndexItemEncPayload(pprq.Payload{Value: values[0], Attributes: values[1:], DeviceID: c.cfg.DeviceID, Timestamp: time.Now().UnixMilli()})
+	if err != nil {
+		return err
+	}
+	return c.push(item)
+}
+
+// AddPoint(int64, int64): encrypt one 2-D reading (e.g. a position on a grid, see pprq.Params.EncodePoint) and buffer it. the buffer is uploaded when it reaches BatchSize, and a failure of that upload is returned as ErrUploadPending
+func (c *Client) AddPoint(x int64, y int64) error {
+	if x < 0 || y < 0 {
+		return fmt.Errorf("%w: negative point (%d,%d)", pprq.ErrValueOutOfDomain, x, y)
+	}
+	item, err := c.seal(c.params.EncodePoint(hilbert.Point{X: uint64(x), Y: uint64(y)}))
+	if err != nil {
+		return err
+	}
+	return c.push(item)
+}
+
+// push(pprq.IndexCipher): buffer one encrypted reading and upload the buffer if it reaches BatchSize. once the reading is buffered, a failed upload is returned as ErrUploadPending
+func (c *Client) push(item pprq.IndexCipher) error {
+	if c.cfg.MaxBuffered > 0 && len(c.pending) >= c.cfg.MaxBuffered {
+		return fmt.Errorf("%w: %d readings", ErrBufferFull, len(c.pending))
+	}
+	c.pending = append(c.pending, item)
+	if c.cfg.BatchSize > 0 && len(c.pending) >= c.cfg.BatchSize {
+		if err := c.Flush(); err != nil {
+			return fmt.Errorf("%w: %w", ErrUploadPending, err)
+		}
+	}
+	return nil
+}
+
+// Buffered(): return the number of readings which have not been uploaded
+func (c *Client) Buffered() int {
+	return len(c.pending)
+}
+
+// Flush(): upload all the buffered readings. the readings stay in the buffer if the upload fails after all the retries (ErrUploadFailed), and the failed batch is sent again with the same idempotency key by the next Flush (before the readings added since), so a batch which reached the fog node without an acknowledgement is not stored twice. a batch rejected by the fog node for good is dropped (ErrBatchRejected), since sending it again would fail again
+func (c *Client) Flush() error {
+	for len(c.pending) > 0 {
+		if c.batch == nil {
+			if err := c.sealBatch(); err != nil {
+				return err
+			}
+		}
+
+		delay := time.Duration(c.cfg.RetryDelayMillis) * time.Millisecond
+		retryable, err := c.upload(c.batch)
+		for retry := 0; retry < c.cfg.MaxRetries && retryable; retry++ {
+			time.Sleep(delay)
+			delay *= 2
+			retryable, err = c.upload(c.batch)
+		}
+		if err != nil && !errors.Is(err, ErrBatchRejected) {
+			return err
+		}
+		c.pending = c.pending[c.batchLen:]
+		c.batch, c.batchLen, c.batchKey = nil, 0, ""
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealBatch(): encode all the pending readings as the next batch with a fresh idempotency key
+func (c *Client) sealBatch() error {
+	var buf bytes.Buffer
+	enc := pprq.NewEncoder(&buf, c.params)
+	for i := range c.pending {
+		if err := enc.Encode(&c.pending[i]); err != nil {
+			return err
+		}
+	}
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("%w: %v", pprq.ErrRandomness, err)
+	}
+	c.batch, c.batchLen, c.batchKey = buf.Bytes(), len(c.pending), hex.EncodeToString(key)
+	return nil
+}
+
+// upload([]byte): send one batch to the fog node and report whether a failure is retryable (network failures, 429 and 5xx). the other failures are ErrBatchRejected
+func (c *Client) upload(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrUploadFailed, err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(IdempotencyHeader, c.batchKey)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("%w: %v", ErrUploadFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return true, fmt.Errorf("%w: %s: %s", ErrUploadFailed, resp.Status, bytes.TrimSpace(msg))
+	}
+	return false, fmt.Errorf("%w: %s: %s", ErrBatchRejected, resp.Status, bytes.TrimSpace(msg))
+}
@@ -0,0 +1,280 @@
+package iot
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JerryXie96/PPRQueryIoT/fog"
+	"github.com/JerryXie96/PPRQueryIoT/pprq"
+)
+
+// attempt: one upload received by the test server
+type attempt struct {
+	key   string    // the idempotency key
+	items int       // the number of items in the batch
+	at    time.Time // the time of arrival
+}
+
+// recorder: a fog node stub which records the uploads and answers them with the status of status(n) for the n-th upload
+type recorder struct {
+	mu       sync.Mutex      // guards attempts
+	attempts []attempt       // the uploads in their order
+	status   func(n int) int // the status of the n-th upload
+}
+
+// ServeHTTP(http.ResponseWriter, *http.Request): record one upload
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	items := 0
+	dec := pprq.NewDecoder(r.Body)
+	for {
+		if _, err := dec.Decode(); err != nil {
+			break
+		}
+		items++
+	}
+	rec.mu.Lock()
+	n := len(rec.attempts)
+	rec.attempts = append(rec.attempts, attempt{key: r.Header.Get(IdempotencyHeader), items: items, at: time.Now()})
+	rec.mu.Unlock()
+	w.WriteHeader(rec.status(n))
+}
+
+// newTestClient(*testing.T, string, int): create a client which uploads only on Flush, with retries after 20ms, 40ms, ...
+func newTestClient(t *testing.T, endpoint string, retries int) *Client {
+	t.Helper()
+	key, err := pprq.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := key.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewConfig(endpoint, "temp")
+	cfg.BatchSize = 0
+	cfg.MaxRetries = retries
+	cfg.RetryDelayMillis = 20
+	c, err := NewClient(cfg, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// addReadings(*testing.T, *Client, ...int64): buffer the readings
+func addReadings(t *testing.T, c *Client, values ...int64) {
+	t.Helper()
+	for _, v := range values {
+		if err := c.Add(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFlushRetry(t *testing.T) {
+	rec := &recorder{status: func(n int) int {
+		if n < 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+	c := newTestClient(t, ts.URL, 3)
+	addReadings(t, c, 1, 2, 3)
+
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Buffered() != 0 {
+		t.Errorf("%d readings buffered after the upload", c.Buffered())
+	}
+	if len(rec.attempts) != 3 {
+		t.Fatalf("%d uploads, want 3", len(rec.attempts))
+	}
+	for i, a := range rec.attempts {
+		if a.key == "" || a.key != rec.attempts[0].key || a.items != 3 {
+			t.Errorf("upload %d: key %q, %d items (the first one: key %q)", i, a.key, a.items, rec.attempts[0].key)
+		}
+	}
+	// the delay is doubled after each retry
+	if d := rec.attempts[1].at.Sub(rec.attempts[0].at); d < 20*time.Millisecond {
+		t.Errorf("first retry after %v, want at least 20ms", d)
+	}
+	if d := rec.attempts[2].at.Sub(rec.attempts[1].at); d < 40*time.Millisecond {
+		t.Errorf("second retry after %v, want at least 40ms", d)
+	}
+}
+
+func TestFlushGiveUp(t *testing.T) {
+	var mu sync.Mutex
+	status := http.StatusInternalServerError
+	rec := &recorder{status: func(int) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return status
+	}}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+	c := newTestClient(t, ts.URL, 2)
+	addReadings(t, c, 1, 2)
+
+	if err := c.Flush(); !errors.Is(err, ErrUploadFailed) {
+		t.Fatalf("got %v, want ErrUploadFailed", err)
+	}
+	if len(rec.attempts) != 3 {
+		t.Errorf("%d uploads, want 3 (one and 2 retries)", len(rec.attempts))
+	}
+	if c.Buffered() != 2 {
+		t.Errorf("%d readings buffered after the failure, want 2", c.Buffered())
+	}
+
+	// the failed batch is sent again as it was, and the readings added since follow in a new batch
+	addReadings(t, c, 3)
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Buffered() != 0 {
+		t.Errorf("%d readings buffered after the upload", c.Buffered())
+	}
+	if len(rec.attempts) != 5 {
+		t.Fatalf("%d uploads, want 5", len(rec.attempts))
+	}
+	failed, again, next := rec.attempts[0], rec.attempts[3], rec.attempts[4]
+	if again.key != failed.key || again.items != 2 {
+		t.Errorf("resent batch: key %q, %d items, want key %q, 2 items", again.key, again.items, failed.key)
+	}
+	if next.key == failed.key || next.items != 1 {
+		t.Errorf("new batch: key %q, %d items, want a new key, 1 item", next.key, next.items)
+	}
+}
+
+func TestFlushNoRetry(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict} {
+		rec := &recorder{status: func(int) int { return status }}
+		ts := httptest.NewServer(rec)
+		c := newTestClient(t, ts.URL, 3)
+		addReadings(t, c, 1)
+		if err := c.Flush(); !errors.Is(err, ErrBatchRejected) {
+			t.Errorf("status %d: got %v, want ErrBatchRejected", status, err)
+		}
+		// the rejected batch is dropped, so the next Flush has nothing to send
+		if len(rec.attempts) != 1 || c.Buffered() != 0 {
+			t.Errorf("status %d: %d uploads, %d readings buffered, want 1 and 0", status, len(rec.attempts), c.Buffered())
+		}
+		if err := c.Flush(); err != nil || len(rec.attempts) != 1 {
+			t.Errorf("status %d: Flush after the rejection: %v, %d uploads", status, err, len(rec.attempts))
+		}
+		ts.Close()
+	}
+}
+
+func TestAddUploadPending(t *testing.T) {
+	rec := &recorder{status: func(int) int { return http.StatusServiceUnavailable }}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+	c := newTestClient(t, ts.URL, 0)
+	c.cfg.BatchSize = 2
+	c.cfg.MaxBuffered = 3
+	addReadings(t, c, 1)
+
+	// the reading which fills the batch is buffered although its upload fails
+	if err := c.Add(2); !errors.Is(err, ErrUploadPending) || !errors.Is(err, ErrUploadFailed) {
+		t.Fatalf("got %v, want ErrUploadPending wrapping ErrUploadFailed", err)
+	}
+	if c.Buffered() != 2 || len(rec.attempts) != 1 {
+		t.Errorf("%d readings buffered, %d uploads, want 2 and 1", c.Buffered(), len(rec.attempts))
+	}
+	if err := c.Add(3); !errors.Is(err, ErrUploadPending) {
+		t.Fatalf("got %v, want ErrUploadPending", err)
+	}
+
+	// the buffer is capped, and the refused reading is not buffered
+	if err := c.Add(4); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("got %v, want ErrBufferFull", err)
+	}
+	if c.Buffered() != 3 {
+		t.Errorf("%d readings buffered, want 3", c.Buffered())
+	}
+}
+
+func TestFlushLostResponse(t *testing.T) {
+	server := fog.NewServer()
+	var once sync.Once
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lost := false
+		once.Do(func() { lost = true })
+		if !lost {
+			server.ServeHTTP(w, r)
+			return
+		}
+		// the first batch is stored, but the connection drops before the response
+		server.ServeHTTP(httptest.NewRecorder(), r)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	defer ts.Close()
+	c := newTestClient(t, ts.URL, 3)
+	addReadings(t, c, 1, 2, 3)
+
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	idx := server.Index("temp")
+	if idx == nil {
+		t.Fatal("the fog node has no index")
+	}
+	if idx.Len() != 3 {
+		t.Errorf("the fog node stores %d items, want 3", idx.Len())
+	}
+}
+
+func TestAddRecord(t *testing.T) {
+	c := newTestClient(t, "http://fog.invalid", 0)
+	r := NewRecord()
+	if err := c.AddRecord(r); !errors.Is(err, pprq.ErrInvalidParams) {
+		t.Errorf("empty record: got %v, want ErrInvalidParams", err)
+	}
+	if err := c.AddRecord(nil); !errors.Is(err, pprq.ErrInvalidParams) {
+		t.Errorf("nil record: got %v, want ErrInvalidParams", err)
+	}
+	readings := []float64{21, 40, 87}
+	for _, f := range readings {
+		r.Add(f)
+	}
+	if r.Len() != len(readings) {
+		t.Fatalf("record of %d readings, want %d", r.Len(), len(readings))
+	}
+	if err := c.AddRecord(r); err != nil {
+		t.Fatal(err)
+	}
+	if c.Buffered() != 1 {
+		t.Fatalf("%d readings buffered, want 1", c.Buffered())
+	}
+
+	// the readings are sealed as the attributes of one record in their order
+	pl, err := c.scheme.OpenPayload(c.pending[0].Payload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := append([]uint64{pl.Value}, pl.Attributes...)
+	if len(got) != len(readings) {
+		t.Fatalf("payload of %d attributes, want %d", len(got), len(readings))
+	}
+	for a, v := range got {
+		if f := c.params.DecodeFloat(v); f != readings[a] {
+			t.Errorf("attribute %d: got %v, want %v", a, f, readings[a])
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package pprq
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// the block sizes and the index sizes covered by the benchmarks (on the default 32-bit domain)
+var (
+	benchBlockSizes = []int{1, 2, 4, 8}
+	benchIndexSizes = []int{100, 1000, 10000}
+)
+
+// benchParams(int): the parameters with the block size and the full sub-index
+func benchParams(blockSize int) Params {
+	p := DefaultParams()
+	p.BlockSize = blockSize
+	p.SubIndexSize = p.cipherNum()
+	return p
+}
+
+// benchValues(Params, int): draw n random values from the domain
+func benchValues(p Params, n int) []uint64 {
+	r := rand.New(rand.NewPCG(1, 2))
+	values := make([]uint64, n)
+	for i := range values {
+		values[i] = randomValue(r, p)
+	}
+	return values
+}
+
+func BenchmarkIndexItemEnc(b *testing.B) {
+	for _, bs := range benchBlockSizes {
+		b.Run(fmt.Sprintf("block=%d", bs), func(b *testing.B) {
+			s := newTestScheme(b, benchParams(bs))
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := s.IndexItemEnc(123456789); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIndexItemsEnc(b *testing.B) {
+	for _, bs := range benchBlockSizes {
+		b.Run(fmt.Sprintf("block=%d", bs), func(b *testing.B) {
+			p := benchParams(bs)
+			s := newTestScheme(b, p)
+			payloads := make([]Payload, 256)
+			for i, v := range benchValues(p, len(payloads)) {
+				payloads[i].Value = v
+			}
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := s.IndexItemsEnc(payloads, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkQueryEnc(b *testing.B) {
+	for _, bs := range benchBlockSizes {
+		b.Run(fmt.Sprintf("block=%d", bs), func(b *testing.B) {
+			s := newTestScheme(b, benchParams(bs))
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := s.QueryEnc(10000, 20000); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	for _, bs := range benchBlockSizes[:3] { // the 8-bit index of 10000 items takes too long to build
+		for _, n := range benchIndexSizes {
+			b.Run(fmt.Sprintf("block=%d/items=%d", bs, n), func(b *testing.B) {
+				p := benchParams(bs)
+				s := newTestScheme(b, p)
+				values := benchValues(p, n)
+				idx, err := s.IndexEnc(values)
+				if err != nil {
+					b.Fatal(err)
+				}
+				q, err := s.QueryEnc(p.MaxValue()/4, p.MaxValue()/2)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := idx.Match(q); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEncryptorAllocs(t *testing.T) {
+	s := newTestScheme(t, DefaultParams())
+	e := s.getEncryptor()
+	e.getHashedValue(0, 1, '<', 5, 1) // create the HMAC, the buffer and the cached prefix
+	for _, blockId := range []int{0, 1} {
+		if n := testing.AllocsPerRun(100, func() { e.getHashedValue(0, 1, '<', 5, blockId) }); n != 0 {
+			t.Errorf("hash of block %d: %v allocations, want 0", blockId, n)
+		}
+	}
+	s.putEncryptor(e)
+
+	// the pooled encryptor saves the HMACs and the prefix cache of every item
+	pooled := testing.AllocsPerRun(20, func() {
+		if _, err := s.IndexItemEnc(123456789); err != nil {
+			t.Fatal(err)
+		}
+	})
+	fresh := testing.AllocsPerRun(20, func() {
+		pl := Payload{Value: 123456789}
+		if _, err := s.indexItemEnc(s.newEncryptor(), &pl); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if pooled >= fresh {
+		t.Errorf("pooled encryptor: %v allocations per item, fresh encryptor: %v", pooled, fresh)
+	}
+	// only the item itself is allocated: per block the sub-index, the ciphertext list, the slab and the growth of the sub-index lists
+	if budget := 10 * s.params.blockNum(); pooled > float64(budget) {
+		t.Errorf("pooled encryptor: %v allocations per item, want at most %d", pooled, budget)
+	}
+}
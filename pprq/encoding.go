@@ -0,0 +1,168 @@
+package pprq
+
+import (
+	"fmt"
+	"math"
+)
+
+// Encoding: the order-preserving mapping from the typed values (signed integers, floats) to the unsigned domain [0, 2^{DomainBits}-1]. the mapping is applied before the block splitting, so the order of the encoded values is the same as the order of the typed values
+type Encoding uint8
+
+const (
+	Unsigned   Encoding = iota // the values are non-negative integers and used as they are (the default)
+	Signed                     // the values are signed integers in [-2^{DomainBits-1}, 2^{DomainBits-1}-1]. the value is offset by 2^{DomainBits-1} (i.e. the sign bit is flipped)
+	FixedPoint                 // the values are floats rounded to Scale decimal digits. the value is scaled by 10^{Scale} and encoded as Signed
+	Float                      // the values are IEEE-754 floats (DomainBits 32: float32, 64: float64) mapped to their total order. NaN is not supported
+)
+
+const maxScale int = 18 // the max number of decimal digits of FixedPoint (10^{18} < 2^{63})
+
+// String(): return the name of the encoding
+func (e Encoding) String() string {
+	switch e {
+	case Unsigned:
+		return "unsigned"
+	case Signed:
+		return "signed"
+	case FixedPoint:
+		return "fixed-point"
+	case Float:
+		return "float"
+	default:
+		return fmt.Sprintf("encoding(%d)", uint8(e))
+	}
+}
+
+// validateEncoding(): check whether the encoding is supported by the domain
+func (p Params) validateEncoding() error {
+	switch p.Encoding {
+	case Unsigned, Signed:
+	case FixedPoint:
+		if p.Scale < 0 || p.Scale > maxScale {
+			return wrapError(ErrInvalidParams, "scale %d out of range [0,%d]", p.Scale, maxScale)
+		}
+		return nil
+	case Float:
+		if p.DomainBits != 32 && p.DomainBits != 64 {
+			return wrapError(ErrInvalidParams, "float encoding requires 32 or 64 domain bits, got %d", p.DomainBits)
+		}
+	default:
+		return wrapError(ErrInvalidParams, "unknown %v", p.Encoding)
+	}
+	if p.Scale != 0 {
+		return wrapError(ErrInvalidParams, "scale is only used by the %v encoding", FixedPoint)
+	}
+	return nil
+}
+
+// signedRange(): the min and the max signed value which can be encoded by Signed and FixedPoint
+func (p Params) signedRange() (int64, int64) {
+	max := int64(p.MaxValue() >> 1)
+	return -max - 1, max
+}
+
+// encodeSigned(int64): offset v by 2^{DomainBits-1}
+func (p Params) encodeSigned(v int64) (uint64, error) {
+	min, max := p.signedRange()
+	if v < min || v > max {
+		return 0, wrapError(ErrValueOutOfDomain, "%d not in [%d,%d]", v, min, max)
+	}
+	return uint64(v-min) & p.MaxValue(), nil
+}
+
+// encodeFloatOrder(float64): map the IEEE-754 bits to the total order (the negative values are inverted, the sign bit of the positive values is set)
+func (p Params) encodeFloatOrder(f float64) (uint64, error) {
+	if math.IsNaN(f) {
+		return 0, wrapError(ErrValueOutOfDomain, "NaN")
+	}
+	if f == 0 { // -0 and +0 are the same value
+		f = 0
+	}
+	if p.DomainBits == 32 {
+		bits := math.Float32bits(float32(f)) // rounded to the nearest float32 (the rounding keeps the order)
+		if bits>>31 == 1 {
+			return uint64(^bits), nil
+		}
+		return uint64(bits | 1<<31), nil
+	}
+	bits := math.Float64bits(f)
+	if bits>>63 == 1 {
+		return ^bits, nil
+	}
+	return bits | 1<<63, nil
+}
+
+// EncodeInt(int64): encode an integer into the domain by the encoding of the parameters
+func (p Params) EncodeInt(v int64) (uint64, error) {
+	switch p.Encoding {
+	case Unsigned:
+		if v < 0 {
+			return 0, wrapError(ErrValueOutOfDomain, "negative %d in the %v encoding", v, p.Encoding)
+		}
+		u := uint64(v)
+		return u, p.checkValue(u)
+	case Signed:
+		return p.encodeSigned(v)
+	case FixedPoint:
+		pow := int64(math.Pow10(p.Scale))
+		min, max := p.signedRange()
+		if v < min/pow || v > max/pow {
+			return 0, wrapError(ErrValueOutOfDomain, "%d not in [%d,%d]", v, min/pow, max/pow)
+		}
+		return p.encodeSigned(v * pow)
+	case Float:
+		return p.encodeFloatOrder(float64(v))
+	default:
+		return 0, wrapError(ErrInvalidParams, "unknown %v", p.Encoding)
+	}
+}
+
+// EncodeFloat(float64): encode a float into the domain by the encoding of the parameters. the integer encodings only accept integral values
+func (p Params) EncodeFloat(f float64) (uint64, error) {
+	switch p.Encoding {
+	case Unsigned, Signed:
+		if f != math.Trunc(f) || math.IsInf(f, 0) {
+			return 0, wrapError(ErrValueOutOfDomain, "non-integral %v in the %v encoding", f, p.Encoding)
+		}
+		if f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, wrapError(ErrValueOutOfDomain, "%v", f)
+		}
+		return p.EncodeInt(int64(f))
+	case FixedPoint:
+		scaled := math.Round(f * math.Pow10(p.Scale))
+		min, max := p.signedRange()
+		if math.IsNaN(scaled) || scaled < float64(min) || scaled >= -float64(min) { // -float64(min) is 2^{DomainBits-1} (float64(max) may be rounded up to it)
+			return 0, wrapError(ErrValueOutOfDomain, "%v not in [%v,%v]", f, float64(min)/math.Pow10(p.Scale), float64(max)/math.Pow10(p.Scale))
+		}
+		return p.encodeSigned(int64(scaled))
+	case Float:
+		return p.encodeFloatOrder(f)
+	default:
+		return 0, wrapError(ErrInvalidParams, "unknown %v", p.Encoding)
+	}
+}
+
+// DecodeFloat(uint64): the inverse of EncodeFloat (the integer encodings return the integer as a float)
+func (p Params) DecodeFloat(u uint64) float64 {
+	min, _ := p.signedRange()
+	switch p.Encoding {
+	case Signed:
+		return float64(int64(u) + min)
+	case FixedPoint:
+		return float64(int64(u)+min) / math.Pow10(p.Scale)
+	case Float:
+		if p.DomainBits == 32 {
+			bits := uint32(u)
+			if bits>>31 == 1 {
+				return float64(math.Float32frombits(bits &^ (1 << 31)))
+			}
+			return float64(math.Float32frombits(^bits))
+		}
+		if u>>63 == 1 {
+			return math.Float64frombits(u &^ (1 << 63))
+		}
+		return math.Float64frombits(^u)
+	default:
+		return float64(u)
+	}
+}
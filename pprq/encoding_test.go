@@ -0,0 +1,163 @@
+package pprq
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// checkOrder(*testing.T, Params, []float64): check that the encoding of the ascending values is ascending and decodes back to them
+func checkOrder(t *testing.T, p Params, values []float64) {
+	t.Helper()
+	var prev uint64
+	for i, f := range values {
+		u, err := p.EncodeFloat(f)
+		if err != nil {
+			t.Fatalf("%v: EncodeFloat(%v): %v", p.Encoding, f, err)
+		}
+		if err := p.checkValue(u); err != nil {
+			t.Fatalf("%v: EncodeFloat(%v) = %d: %v", p.Encoding, f, u, err)
+		}
+		if i > 0 && u <= prev {
+			t.Errorf("%v: EncodeFloat(%v) = %d, not above EncodeFloat(%v) = %d", p.Encoding, f, u, values[i-1], prev)
+		}
+		if back := p.DecodeFloat(u); back != f {
+			t.Errorf("%v: DecodeFloat(EncodeFloat(%v)) = %v", p.Encoding, f, back)
+		}
+		prev = u
+	}
+}
+
+func TestEncodingOrder(t *testing.T) {
+	signed := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 16, Encoding: Signed}
+	checkOrder(t, signed, []float64{-32768, -32767, -1000, -1, 0, 1, 1000, 32766, 32767})
+
+	fixed := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 32, Encoding: FixedPoint, Scale: 2}
+	checkOrder(t, fixed, []float64{-21474836.48, -20.5, -0.01, 0, 0.01, 0.5, 36.6, 21474836.47})
+
+	float32s := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 32, Encoding: Float}
+	checkOrder(t, float32s, []float64{math.Inf(-1), -math.MaxFloat32, -1.5, -math.SmallestNonzeroFloat32, 0, math.SmallestNonzeroFloat32, 1.5, math.MaxFloat32, math.Inf(1)})
+
+	float64s := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 64, Encoding: Float}
+	checkOrder(t, float64s, []float64{math.Inf(-1), -math.MaxFloat64, -1e-300, -math.SmallestNonzeroFloat64, 0, math.SmallestNonzeroFloat64, 1e-300, math.MaxFloat64, math.Inf(1)})
+
+	// the integers and the floats of one encoding are mapped to the same values
+	for _, p := range []Params{signed, fixed, float64s} {
+		for _, v := range []int64{-100, 0, 100} {
+			ui, err := p.EncodeInt(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			uf, err := p.EncodeFloat(float64(v))
+			if err != nil || ui != uf {
+				t.Errorf("%v: EncodeInt(%d) = %d, EncodeFloat = %d, %v", p.Encoding, v, ui, uf, err)
+			}
+		}
+	}
+}
+
+func TestEncodingBoundaries(t *testing.T) {
+	unsigned := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 8}
+	signed := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 8, Encoding: Signed}
+	fixed := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 16, Encoding: FixedPoint, Scale: 2}
+	float32s := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 32, Encoding: Float}
+
+	// the ends of the domain
+	for _, c := range []struct {
+		p    Params
+		v    int64
+		want uint64
+	}{
+		{unsigned, 0, 0},
+		{unsigned, 255, 255},
+		{signed, -128, 0},
+		{signed, 127, 255},
+		{fixed, -327, 32768 - 32700},
+		{fixed, 327, 32768 + 32700},
+	} {
+		if u, err := c.p.EncodeInt(c.v); err != nil || u != c.want {
+			t.Errorf("%v: EncodeInt(%d) = %d, %v, want %d", c.p.Encoding, c.v, u, err, c.want)
+		}
+	}
+
+	// the values out of the domain
+	for _, c := range []struct {
+		p Params
+		v int64
+	}{
+		{unsigned, -1},
+		{unsigned, 256},
+		{signed, -129},
+		{signed, 128},
+		{fixed, -328}, // -32800 after the scaling
+		{fixed, 328},
+	} {
+		if _, err := c.p.EncodeInt(c.v); !errors.Is(err, ErrValueOutOfDomain) {
+			t.Errorf("%v: EncodeInt(%d): got %v, want ErrValueOutOfDomain", c.p.Encoding, c.v, err)
+		}
+	}
+	for _, c := range []struct {
+		p Params
+		f float64
+	}{
+		{unsigned, 1.5},
+		{unsigned, math.Inf(1)},
+		{signed, math.NaN()},
+		{signed, math.Inf(-1)},
+		{signed, 1e30},
+		{fixed, math.NaN()},
+		{fixed, math.Inf(1)},
+		{fixed, 327.68},
+		{fixed, -327.69},
+		{float32s, math.NaN()},
+	} {
+		if _, err := c.p.EncodeFloat(c.f); !errors.Is(err, ErrValueOutOfDomain) {
+			t.Errorf("%v: EncodeFloat(%v): got %v, want ErrValueOutOfDomain", c.p.Encoding, c.f, err)
+		}
+	}
+
+	// -0 and +0 are the same value
+	for _, p := range []Params{signed, fixed, float32s, {BlockSize: 2, SubIndexSize: 3, DomainBits: 64, Encoding: Float}} {
+		neg, err := p.EncodeFloat(math.Copysign(0, -1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pos, _ := p.EncodeFloat(0); neg != pos {
+			t.Errorf("%v: -0 encoded as %d, +0 as %d", p.Encoding, neg, pos)
+		}
+	}
+
+	// the fixed-point values are rounded to Scale digits
+	if u, err := fixed.EncodeFloat(1.234); err != nil || fixed.DecodeFloat(u) != 1.23 {
+		t.Errorf("EncodeFloat(1.234) decodes to %v, %v", fixed.DecodeFloat(u), err)
+	}
+}
+
+func TestEncodingParams(t *testing.T) {
+	for _, p := range []Params{
+		{BlockSize: 2, SubIndexSize: 3, DomainBits: 64, Encoding: FixedPoint, Scale: maxScale + 1}, // the scale overflows 2^63
+		{BlockSize: 2, SubIndexSize: 3, DomainBits: 64, Encoding: FixedPoint, Scale: -1},
+		{BlockSize: 2, SubIndexSize: 3, DomainBits: 32, Encoding: Signed, Scale: 2}, // the scale is only used by FixedPoint
+		{BlockSize: 2, SubIndexSize: 3, DomainBits: 16, Encoding: Float},            // neither float32 nor float64
+		{BlockSize: 2, SubIndexSize: 3, DomainBits: 32, Encoding: Float + 1},
+	} {
+		if err := p.Validate(); !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("%+v: got %v, want ErrInvalidParams", p, err)
+		}
+	}
+
+	// the largest scale still encodes its range, and the values whose scaled form overflows int64 are rejected
+	p := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 64, Encoding: FixedPoint, Scale: maxScale}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := p.EncodeInt(9); err != nil || p.DecodeFloat(u) != 9 {
+		t.Errorf("scale %d: EncodeInt(9) decodes to %v, %v", maxScale, p.DecodeFloat(u), err)
+	}
+	if _, err := p.EncodeInt(10); !errors.Is(err, ErrValueOutOfDomain) {
+		t.Errorf("scale %d: EncodeInt(10): got %v, want ErrValueOutOfDomain", maxScale, err)
+	}
+	if _, err := p.EncodeFloat(1e300); !errors.Is(err, ErrValueOutOfDomain) {
+		t.Errorf("scale %d: EncodeFloat(1e300): got %v, want ErrValueOutOfDomain", maxScale, err)
+	}
+}
@@ -0,0 +1,113 @@
+package pprq
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"hash"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// encryptor: the reusable state of the encryption, i.e. the HMACs keyed by the keys of the attributes and the hashes of the recent prefixes. it is not safe for concurrent use, so the scheme keeps a pool of them
+type encryptor struct {
+	keys     [][]byte                     // the HMAC keys of the attributes (see Scheme.attrKeys)
+	keyMACs  []hash.Hash                  // the HMACs keyed by keys, created on the first use and reset before each use
+	prefixes map[uint64][sha256.Size]byte // the SHA256 of the decimal prefixes (the prefixes of the high blocks are shared by many values)
+	buf      []byte                       // the scratch buffer of iStr and the decimal prefix
+	digest   [sha256.Size]byte            // the scratch of the digests written to and read from the HMACs (a local array would escape through hash.Hash)
+}
+
+const maxCachedPrefixes int = 4096 // the number of prefix hashes kept by one encryptor
+
+// newEncryptor(): create an encryptor for the key of the scheme
+func (s *Scheme) newEncryptor() *encryptor {
+	return &encryptor{
+		keys:     s.attrKeys,
+		keyMACs:  make([]hash.Hash, len(s.attrKeys)),
+		prefixes: make(map[uint64][sha256.Size]byte),
+	}
+}
+
+// keyMAC(int): return the HMAC keyed by the key of the attribute
+func (e *encryptor) keyMAC(attribute int) hash.Hash {
+	if e.keyMACs[attribute] == nil {
+		e.keyMACs[attribute] = hmac.New(sha256.New, e.keys[attribute])
+	}
+	return e.keyMACs[attribute]
+}
+
+// getEncryptor(): take an encryptor from the pool of the scheme (return it by putEncryptor)
+func (s *Scheme) getEncryptor() *encryptor {
+	if e, ok := s.encryptors.Get().(*encryptor); ok {
+		return e
+	}
+	return s.newEncryptor()
+}
+
+// putEncryptor(*encryptor): return the encryptor to the pool
+func (s *Scheme) putEncryptor(e *encryptor) {
+	s.encryptors.Put(e)
+}
+
+// hashedPrefix(uint64): return H(prefix), i.e. the SHA256 of the decimal prefix
+func (e *encryptor) hashedPrefix(prefix uint64) [sha256.Size]byte {
+	if h, ok := e.prefixes[prefix]; ok {
+		return h
+	}
+	if len(e.prefixes) >= maxCachedPrefixes {
+		clear(e.prefixes)
+	}
+	e.buf = strconv.AppendUint(e.buf[:0], prefix, 10)
+	h := sha256.Sum256(e.buf)
+	e.prefixes[prefix] = h
+	return h
+}
+
+// getHashedValue: compute the hash value in the power part of index and query (i.e. G_K(H(prefix),iStr)) (K: the key of the attribute, iStr: the block value and the operator, blockId: the current block number)
+func (e *encryptor) getHashedValue(attribute int, block int64, operator byte, prefix uint64, blockId int) [sha256.Size]byte {
+	hmac_ins := e.keyMAC(attribute)
+	hmac_ins.Reset()
+	if blockId > 0 { // include the prefix: put H(prefix) before iStr
+		e.digest = e.hashedPrefix(prefix)
+		hmac_ins.Write(e.digest[:])
+	}
+	e.buf = append(strconv.AppendInt(e.buf[:0], block, 10), operator) // iStr
+	hmac_ins.Write(e.buf)
+	hmac_ins.Sum(e.digest[:0])
+	return e.digest
+}
+
+// IndexItemsEnc([]Payload, int): encrypt the values of the payloads as the index items with a pool of workers (0: runtime.GOMAXPROCS), e.g. the readings collected by a gateway. the items are in the order of payloads, and the first error (in that order) is returned
+func (s *Scheme) IndexItemsEnc(payloads []Payload, workers int) ([]IndexCipher, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(payloads))
+
+	var (
+		wg    sync.WaitGroup
+		next  atomic.Int64 // the position of the next payload to be encrypted
+		items = make([]IndexCipher, len(payloads))
+		errs  = make([]error, len(payloads))
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e := s.getEncryptor()
+			defer s.putEncryptor(e)
+			for i := int(next.Add(1) - 1); i < len(payloads); i = int(next.Add(1) - 1) {
+				items[i], errs[i] = s.indexItemEnc(e, &payloads[i])
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
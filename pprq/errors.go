@@ -0,0 +1,40 @@
+package pprq
+
+import (
+	"errors"
+	"fmt"
+)
+
+// the errors returned by the public operations. they are wrapped with the details, so they should be checked with errors.Is
+var (
+	ErrRandomness       = errors.New("pprq: randomness unavailable")   // the system RNG failed, so no key or nonce can be generated
+	ErrInvalidParams    = errors.New("pprq: invalid parameters")       // the parameters are not supported
+	ErrInvalidKey       = errors.New("pprq: invalid key")              // the key material is unusable
+	ErrValueOutOfDomain = errors.New("pprq: value out of domain")      // the value cannot be encoded into the domain of the parameters
+	ErrParamMismatch    = errors.New("pprq: parameter mismatch")       // the parameters of an item or a query do not match the parameters of the index
+	ErrMalformedIndex   = errors.New("pprq: malformed index encoding") // the encoding of the index or the index item is invalid
+	ErrMalformedToken   = errors.New("pprq: malformed query token")    // the encoding of the query token is invalid
+	ErrNoSuchItem       = errors.New("pprq: no such item")             // the position is out of the index
+	ErrConflict         = errors.New("pprq: conflicting replacement")  // the item has been replaced since it was read
+	ErrInvalidPayload   = errors.New("pprq: invalid payload")          // the payload is too large, or it cannot be authenticated or decoded
+)
+
+// wrapError(error, string, ...any): attach the details to one of the errors above
+func wrapError(err error, format string, a ...any) error {
+	return fmt.Errorf("%w: %s", err, fmt.Sprintf(format, a...))
+}
+
+// malformed(string, ...any): wrap the reason of the index decoding failure
+func malformed(format string, a ...any) error {
+	return wrapError(ErrMalformedIndex, format, a...)
+}
+
+// malformedToken(string, ...any): wrap the reason of the token decoding failure
+func malformedToken(format string, a ...any) error {
+	return wrapError(ErrMalformedToken, format, a...)
+}
+
+// mismatch(Params, Params): report that the parameters of an item or a query do not match the parameters of the index (or the stream)
+func mismatch(got Params, want Params) error {
+	return wrapError(ErrParamMismatch, "got %+v, want %+v", got, want)
+}
@@ -0,0 +1,59 @@
+package pprq
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Hashing: how the HMAC digests of the blocks are turned into the sub-indexes and the ciphers. the items and the queries must use the same hashing
+type Hashing uint8
+
+const (
+	HashBytes  Hashing = iota // the sub-index is the first 8 bytes of the digest mod SubIndexSize, and the whole digest is the input of F (the default)
+	HashBigInt                // the hashing of the original prototype, which treats the digest as a big integer: the sub-index is the digest mod SubIndexSize, and the digest without its leading zero bytes is the input of F. the encodings of the format version 4 use it
+)
+
+// String(): return the name of the hashing
+func (h Hashing) String() string {
+	switch h {
+	case HashBytes:
+		return "bytes"
+	case HashBigInt:
+		return "big-int"
+	default:
+		return fmt.Sprintf("hashing(%d)", uint8(h))
+	}
+}
+
+// validateHashing(): check whether the hashing is supported
+func (p Params) validateHashing() error {
+	if p.Hashing != HashBytes && p.Hashing != HashBigInt {
+		return wrapError(ErrInvalidParams, "unknown %v", p.Hashing)
+	}
+	return nil
+}
+
+// subIndexOf(*[sha256.Size]byte): calculate the sub-index value of the digest (G_k mod subIndexSize)
+func (p Params) subIndexOf(digest *[sha256.Size]byte) uint8 {
+	m := uint64(p.SubIndexSize)
+	if p.Hashing == HashBigInt { // the digest as a big-endian integer mod m, computed byte by byte
+		var r uint64
+		for _, b := range digest {
+			r = (r<<8 | uint64(b)) % m
+		}
+		return uint8(r)
+	}
+	return uint8(binary.BigEndian.Uint64(digest[:8]) % m)
+}
+
+// cipherOf(*[sha256.Size]byte): return the bytes of the digest which are the input of F (the query cipher)
+func (p Params) cipherOf(digest *[sha256.Size]byte) []byte {
+	b := digest[:]
+	if p.Hashing == HashBigInt { // the bytes of the big integer have no leading zeros
+		for len(b) > 0 && b[0] == 0 {
+			b = b[1:]
+		}
+	}
+	return b
+}
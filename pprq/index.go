@@ -0,0 +1,196 @@
+package pprq
+
+import (
+	"strconv"
+	"sync"
+)
+
+// ItemID: the stable identity of one item in an index. it is assigned when the item is appended and never reused, so it survives the updates, the deletions of other items and the compaction
+type ItemID uint64
+
+// Index: the encrypted index of IoT devices. the items can be appended, updated and deleted, and it is safe to search it while other goroutines modify it
+type Index struct {
+	mu     sync.RWMutex   // guards the fields below except params
+	params Params         // the parameters which the index is encrypted with
+	items  []IndexCipher  // the encrypted index items in the order of their IDs. a deleted item stays as a tombstone (an empty item) until the compaction
+	ids    []ItemID       // the ID of each item in items
+	slots  map[ItemID]int // the position in items of each live item
+	nextID ItemID         // the ID of the next appended item
+}
+
+// Revision: the revision of one item. it is taken from the random nonce of the item, so every encryption of an item (e.g. an update, or the re-encryption by Rotation) has a new revision
+type Revision uint64
+
+// String(): return the ID in decimal
+func (id ItemID) String() string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// String(): return the revision in decimal
+func (r Revision) String() string {
+	return strconv.FormatUint(uint64(r), 10)
+}
+
+// newIndex(Params): create an empty index without checking the parameters
+func newIndex(p Params) *Index {
+	return &Index{params: p, slots: make(map[ItemID]int)}
+}
+
+// NewIndex(Params): create an empty index for the items encrypted with the parameters p
+func NewIndex(p Params) (*Index, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return newIndex(p), nil
+}
+
+// add(ItemID, IndexCipher): append one checked item with the ID id (not less than nextID)
+func (idx *Index) add(id ItemID, item IndexCipher) {
+	idx.slots[id] = len(idx.items)
+	idx.items = append(idx.items, item)
+	idx.ids = append(idx.ids, id)
+	idx.nextID = id + 1
+}
+
+// deleted(int): check whether the item at position i is a tombstone
+func (idx *Index) deleted(i int) bool {
+	return idx.items[i].attributes == nil
+}
+
+// Append(IndexCipher): append one encrypted item to the index and return its ID
+func (idx *Index) Append(item IndexCipher) (ItemID, error) {
+	ids, err := idx.AppendItems([]IndexCipher{item})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// AppendItems([]IndexCipher): append the encrypted items to the index and return their IDs. either all or none of them are appended
+func (idx *Index) AppendItems(items []IndexCipher) ([]ItemID, error) {
+	for i := range items {
+		if items[i].params != idx.params {
+			return nil, mismatch(items[i].params, idx.params)
+		}
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	ids := make([]ItemID, len(items))
+	for i := range items {
+		ids[i] = idx.nextID
+		idx.add(ids[i], items[i])
+	}
+	return ids, nil
+}
+
+// Update(ItemID, IndexCipher): replace the item with the ID id (e.g. a corrected reading). the item keeps its ID
+func (idx *Index) Update(id ItemID, item IndexCipher) error {
+	return idx.replace([]ItemID{id}, nil, []IndexCipher{item})
+}
+
+// Replace([]ItemID, []Revision, []IndexCipher): replace the items with the IDs ids by items if they still have the revisions revisions, i.e. they have not been replaced since they were read (e.g. the items re-encrypted by Rotation must not undo an Update in between). either all or none of them are replaced, ErrConflict reports a changed item, and ErrInvalidParams the counts which do not match
+func (idx *Index) Replace(ids []ItemID, revisions []Revision, items []IndexCipher) error {
+	if len(revisions) != len(ids) {
+		return wrapError(ErrInvalidParams, "%d revisions for %d IDs", len(revisions), len(ids))
+	}
+	return idx.replace(ids, revisions, items)
+}
+
+// replace([]ItemID, []Revision, []IndexCipher): Replace, without checking the revisions if revisions is nil
+func (idx *Index) replace(ids []ItemID, revisions []Revision, items []IndexCipher) error {
+	if len(ids) != len(items) {
+		return wrapError(ErrInvalidParams, "%d IDs for %d items", len(ids), len(items))
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for i, id := range ids {
+		slot, ok := idx.slots[id]
+		if !ok {
+			return wrapError(ErrNoSuchItem, "item %s", id)
+		}
+		if items[i].params != idx.params {
+			return mismatch(items[i].params, idx.params)
+		}
+		if revisions != nil && idx.items[slot].Revision() != revisions[i] {
+			return wrapError(ErrConflict, "item %s has revision %s (want %s)", id, idx.items[slot].Revision(), revisions[i])
+		}
+	}
+	for i, id := range ids {
+		idx.items[idx.slots[id]] = items[i]
+	}
+	return nil
+}
+
+// Delete(ItemID): delete the item with the ID id. it becomes a tombstone, and the tombstones are removed once they take more than half of the index
+func (idx *Index) Delete(id ItemID) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	slot, ok := idx.slots[id]
+	if !ok {
+		return wrapError(ErrNoSuchItem, "item %s", id)
+	}
+	idx.items[slot] = IndexCipher{}
+	delete(idx.slots, id)
+	if 2*len(idx.slots) < len(idx.items) {
+		idx.compact()
+	}
+	return nil
+}
+
+// Compact(): remove the tombstones of the deleted items. the IDs of the remaining items do not change
+func (idx *Index) Compact() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.compact()
+}
+
+// compact(): remove the tombstones. the caller holds the write lock
+func (idx *Index) compact() {
+	live := 0
+	for i := range idx.items {
+		if idx.deleted(i) {
+			continue
+		}
+		idx.items[live], idx.ids[live] = idx.items[i], idx.ids[i]
+		idx.slots[idx.ids[live]] = live
+		live++
+	}
+	clear(idx.items[live:]) // release the ciphertexts of the moved items
+	idx.items, idx.ids = idx.items[:live], idx.ids[:live]
+}
+
+// Item(ItemID): return the item with the ID id
+func (idx *Index) Item(id ItemID) (IndexCipher, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	slot, ok := idx.slots[id]
+	if !ok {
+		return IndexCipher{}, wrapError(ErrNoSuchItem, "item %s", id)
+	}
+	return idx.items[slot], nil
+}
+
+// IDs(): return the IDs of all the items in ascending order
+func (idx *Index) IDs() []ItemID {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	ids := make([]ItemID, 0, len(idx.slots))
+	for i := range idx.items {
+		if !idx.deleted(i) {
+			ids = append(ids, idx.ids[i])
+		}
+	}
+	return ids
+}
+
+// Len(): return the number of items in the index (the deleted items are not counted)
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.slots)
+}
+
+// Params(): return the parameters which the index is encrypted with
+func (idx *Index) Params() Params {
+	return idx.params
+}
@@ -0,0 +1,206 @@
+package pprq
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+/*
+	The export formats of the key:
+
+	raw:    magic "PPRK" (4 bytes) | version (1 byte) | keyRaw (1 byte) | len (uvarint) | key material
+	sealed: magic "PPRK" (4 bytes) | version (1 byte) | keySealed (1 byte) | key ID (8 bytes) | argon2id time (1 byte) | argon2id memory in KiB (uvarint) | argon2id threads (1 byte) | salt (16 bytes) | nonce (12 bytes) | AES-256-GCM(key material)
+
+	The sealed form is encrypted by the key derived from the passphrase with argon2id, and everything before the ciphertext is authenticated as the additional data.
+*/
+
+// KeyID: the identity of a key. it is derived from the key material, so the same key always has the same ID
+type KeyID [8]byte
+
+// Key: the secret HMAC key of the data owner
+type Key struct {
+	id       KeyID  // the identity of the key
+	material []byte // the HMAC key
+}
+
+const (
+	keySize    int    = 32     // the length of a generated key (256 bits)
+	minKeySize int    = 32     // the min length of an imported key
+	maxKeySize int    = 1024   // the max length of an imported key
+	keyMagic   string = "PPRK" // the magic number of the exported key
+	keyVersion byte   = 1      // the version of the export formats
+	keyRaw     byte   = 1      // the raw form
+	keySealed  byte   = 2      // the passphrase-protected form
+
+	saltSize      int    = 16        // the length of the salt of argon2id
+	sealTime      uint32 = 3         // the default number of passes of argon2id
+	sealMemory    uint32 = 64 * 1024 // the default memory of argon2id in KiB (64 MiB)
+	sealThreads   uint8  = 4         // the default parallelism of argon2id
+	maxSealMemory uint32 = 1 << 20   // the max memory accepted when opening a sealed key (1 GiB)
+)
+
+// String(): return the key ID in hex
+func (id KeyID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// GenerateKey(): generate a fresh random key
+func GenerateKey() (*Key, error) {
+	material := make([]byte, keySize)
+	if _, err := rand.Read(material); err != nil {
+		return nil, wrapError(ErrRandomness, "%v", err)
+	}
+	return NewKey(material)
+}
+
+// NewKey([]byte): create a key from the key material (e.g. a provisioned key)
+func NewKey(material []byte) (*Key, error) {
+	if len(material) < minKeySize || len(material) > maxKeySize {
+		return nil, wrapError(ErrInvalidKey, "key length %d not in [%d,%d]", len(material), minKeySize, maxKeySize)
+	}
+	k := &Key{material: bytes.Clone(material)}
+	mac := hmac.New(sha256.New, k.material)
+	mac.Write([]byte("pprq key id"))
+	copy(k.id[:], mac.Sum(nil))
+	return k, nil
+}
+
+// ID(): return the identity of the key
+func (k *Key) ID() KeyID {
+	return k.id
+}
+
+// MarshalBinary(): export the key in the raw form. the result is the secret key, so it must be stored or transferred securely
+func (k *Key) MarshalBinary() ([]byte, error) {
+	b := append([]byte(keyMagic), keyVersion, keyRaw)
+	b = binary.AppendUvarint(b, uint64(len(k.material)))
+	return append(b, k.material...), nil
+}
+
+// readKeyHeader(*bytes.Reader, byte): read the header of the exported key and check its form
+func readKeyHeader(r *bytes.Reader, form byte) error {
+	var hdr [6]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return wrapError(ErrInvalidKey, "truncated header")
+	}
+	if string(hdr[:4]) != keyMagic {
+		return wrapError(ErrInvalidKey, "bad magic %q", hdr[:4])
+	}
+	if hdr[4] != keyVersion {
+		return wrapError(ErrInvalidKey, "unsupported version %d", hdr[4])
+	}
+	if hdr[5] != form {
+		return wrapError(ErrInvalidKey, "unexpected form %d (want %d)", hdr[5], form)
+	}
+	return nil
+}
+
+// UnmarshalBinary([]byte): import a key exported by MarshalBinary
+func (k *Key) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := readKeyHeader(r, keyRaw); err != nil {
+		return err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n != uint64(r.Len()) {
+		return wrapError(ErrInvalidKey, "bad key length")
+	}
+	imported, err := NewKey(data[len(data)-r.Len():])
+	if err != nil {
+		return err
+	}
+	*k = *imported
+	return nil
+}
+
+// sealingKey([]byte, []byte, uint32, uint32, uint8): derive the AES-GCM instance from the passphrase with argon2id
+func sealingKey(passphrase []byte, salt []byte, time uint32, memory uint32, threads uint8) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(argon2.IDKey(passphrase, salt, time, memory, threads, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal([]byte): export the key in the passphrase-protected form
+func (k *Key) Seal(passphrase []byte) ([]byte, error) {
+	b := append([]byte(keyMagic), keyVersion, keySealed)
+	b = append(b, k.id[:]...)
+	b = append(b, byte(sealTime))
+	b = binary.AppendUvarint(b, uint64(sealMemory))
+	b = append(b, sealThreads)
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, wrapError(ErrRandomness, "%v", err)
+	}
+	aead, err := sealingKey(passphrase, salt, sealTime, sealMemory, sealThreads)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, wrapError(ErrRandomness, "%v", err)
+	}
+	b = append(b, salt...)
+	b = append(b, nonce...)
+	return aead.Seal(b[:len(b):len(b)], nonce, k.material, b), nil // the ciphertext is appended to a new array since it must not overlap the additional data
+}
+
+// OpenKey([]byte, []byte): import a key exported by Seal
+func OpenKey(sealed []byte, passphrase []byte) (*Key, error) {
+	var id KeyID
+	r := bytes.NewReader(sealed)
+	if err := readKeyHeader(r, keySealed); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, id[:]); err != nil {
+		return nil, wrapError(ErrInvalidKey, "truncated key ID")
+	}
+	time, err := r.ReadByte()
+	if err != nil || time == 0 {
+		return nil, wrapError(ErrInvalidKey, "bad argon2id time")
+	}
+	memory, err := binary.ReadUvarint(r)
+	if err != nil || memory == 0 || memory > uint64(maxSealMemory) {
+		return nil, wrapError(ErrInvalidKey, "bad argon2id memory")
+	}
+	threads, err := r.ReadByte()
+	if err != nil || threads == 0 {
+		return nil, wrapError(ErrInvalidKey, "bad argon2id threads")
+	}
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, wrapError(ErrInvalidKey, "truncated salt")
+	}
+	aead, err := sealingKey(passphrase, salt, uint32(time), uint32(memory), threads)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, wrapError(ErrInvalidKey, "truncated nonce")
+	}
+	header := sealed[:len(sealed)-r.Len()]
+	material, err := aead.Open(nil, nonce, sealed[len(header):], header)
+	if err != nil {
+		return nil, wrapError(ErrInvalidKey, "wrong passphrase or corrupted key")
+	}
+	k, err := NewKey(material)
+	if err != nil {
+		return nil, err
+	}
+	if k.id != id {
+		return nil, wrapError(ErrInvalidKey, "key ID mismatch")
+	}
+	return k, nil
+}
@@ -0,0 +1,118 @@
+package pprq
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestKeyExport(t *testing.T) {
+	k, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := k.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imported Key
+	if err := imported.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if imported.ID() != k.ID() || !bytes.Equal(imported.material, k.material) {
+		t.Errorf("imported key %v, want %v", imported.ID(), k.ID())
+	}
+
+	// the same material has the same ID, and another material another one
+	same, err := NewKey(k.material)
+	if err != nil || same.ID() != k.ID() {
+		t.Errorf("NewKey of the same material: ID %v, %v, want %v", same.ID(), err, k.ID())
+	}
+	other, err := GenerateKey()
+	if err != nil || other.ID() == k.ID() {
+		t.Errorf("two generated keys share the ID %v (%v)", k.ID(), err)
+	}
+
+	for _, material := range [][]byte{nil, make([]byte, minKeySize-1), make([]byte, maxKeySize+1)} {
+		if _, err := NewKey(material); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("NewKey of %d bytes: got %v, want ErrInvalidKey", len(material), err)
+		}
+	}
+	// modify(...): copy the raw form and change it
+	modify := func(f func([]byte) []byte) []byte {
+		return f(slices.Clone(b))
+	}
+	for _, c := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", modify(func(d []byte) []byte { d[0] = 'X'; return d })},
+		{"future version", modify(func(d []byte) []byte { d[4] = keyVersion + 1; return d })},
+		{"sealed form", modify(func(d []byte) []byte { d[5] = keySealed; return d })},
+		{"truncated", b[:len(b)-1]},
+		{"trailing bytes", append(slices.Clone(b), 0)},
+	} {
+		if err := imported.UnmarshalBinary(c.data); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%s: got %v, want ErrInvalidKey", c.name, err)
+		}
+	}
+}
+
+func TestKeySeal(t *testing.T) {
+	k, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase := []byte("correct horse battery staple")
+	sealed, err := k.Seal(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := OpenKey(sealed, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.ID() != k.ID() || !bytes.Equal(opened.material, k.material) {
+		t.Errorf("opened key %v, want %v", opened.ID(), k.ID())
+	}
+	if bytes.Contains(sealed, k.material) {
+		t.Error("the sealed form holds the key material in the clear")
+	}
+	// the salt and the nonce are fresh, so two seals of the same key differ
+	if again, err := k.Seal(passphrase); err != nil || bytes.Equal(again, sealed) {
+		t.Errorf("two seals are equal (%v)", err)
+	}
+
+	if _, err := OpenKey(sealed, []byte("wrong passphrase")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("wrong passphrase: got %v, want ErrInvalidKey", err)
+	}
+
+	// modify(...): copy the sealed form and change it
+	modify := func(f func([]byte) []byte) []byte {
+		return f(slices.Clone(sealed))
+	}
+	id := 6                       // the position of the key ID
+	salt := id + len(KeyID{}) + 5 // the position of the salt (the memory of 64 MiB takes a 3-byte uvarint)
+	for _, c := range []struct {
+		name string
+		data []byte
+	}{
+		{"raw form", modify(func(d []byte) []byte { d[5] = keyRaw; return d })},
+		{"tampered key ID", modify(func(d []byte) []byte { d[id] ^= 1; return d })},
+		{"tampered threads", modify(func(d []byte) []byte { d[salt-1] = 1; return d })},
+		{"tampered salt", modify(func(d []byte) []byte { d[salt] ^= 1; return d })},
+		{"tampered nonce", modify(func(d []byte) []byte { d[salt+saltSize] ^= 1; return d })},
+		{"tampered ciphertext", modify(func(d []byte) []byte { d[len(d)-20] ^= 1; return d })},
+		{"tampered tag", modify(func(d []byte) []byte { d[len(d)-1] ^= 1; return d })},
+		{"zero time", modify(func(d []byte) []byte { d[id+len(KeyID{})] = 0; return d })},
+		{"huge memory", modify(func(d []byte) []byte { d[id+len(KeyID{})+3] = 0x7f; return d })}, // 2^27 KiB, above maxSealMemory
+		{"truncated salt", sealed[:salt+1]},
+		{"truncated ciphertext", sealed[:len(sealed)-1]},
+	} {
+		if _, err := OpenKey(c.data, passphrase); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%s: got %v, want ErrInvalidKey", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package pprq
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+/*
+	The binary format of the encrypted index (all the integers are unsigned varints unless noted):
+
+	header:  magic "PPRQ" (4 bytes) | version (1 byte) | kind (1 byte) | BlockSize | SubIndexSize | DomainBits | Encoding | Scale | Hashing (1 byte each)
+	index:   header (kindIndex) | the next item ID | the number of items | items with IDs (each: item ID (ascending) | len | item body)
+	stream:  header (kindStream) | items until EOF
+	item:    header (kindItem) | item body
+	items:   the length of the item body | item body
+
+	item body: len(gamma) | gamma | key ID (8 bytes) | len(payload) | payload (see payload.go) | blocks | the number of the other attributes | attributes (each: len(gamma) | gamma | blocks)
+	blocks:    blockNum blocks (each: sub-index lists (SubIndexSize, each: count | positions (1 byte each)) | ciphers (cipherNum, each: len | cipher))
+
+	The gamma and the blocks before the key ID belong to attribute 0, and the other attributes of a record (see record.go) follow its blocks. Before version 6 the item of a single value ends with the blocks of attribute 0 (the count of the other attributes is only written for a record), so such a body cannot tell a record truncated before its other attributes; version 6 always writes the count (0 for a single value).
+*/
+
+const (
+	formatMagic   string = "PPRQ" // the magic number at the beginning of every encoding
+	formatVersion byte   = 6      // the version of the format (2: the key ID is added to the items and the tokens, 3: the plaintext note is replaced by the sealed payload, 4: the item IDs are added to the index, 5: Hashing is added to the header, 6: the item body always holds the count of the other attributes)
+	legacyVersion byte   = 4      // the oldest version which can be read. its header has no Hashing, which is HashBigInt
+	countVersion  byte   = 6      // the oldest version whose item body always holds the count of the other attributes
+	headerSize    int    = 12     // the length of the header
+
+	kindIndex       byte = 1 // a whole index
+	kindItem        byte = 2 // a single index item
+	kindStream      byte = 3 // a stream of index items written by Encoder
+	kindToken       byte = 4 // a query token of one interval (see token.go)
+	kindRanges      byte = 5 // a query token of any number of intervals (see token.go)
+	kindConjunction byte = 6 // a query token of a conjunction of conditions on several attributes (see token.go)
+)
+
+// header: the decoded header of an encoding
+type header struct {
+	params  Params // the parameters of the encoding
+	kind    byte   // the kind of the encoding
+	version byte   // the version of the format, which the item body depends on
+}
+
+// appendHeader([]byte, byte, Params): append the header of kind to b
+func appendHeader(b []byte, kind byte, p Params) []byte {
+	b = append(b, formatMagic...)
+	return append(b, formatVersion, kind, byte(p.BlockSize), byte(p.SubIndexSize), byte(p.DomainBits), byte(p.Encoding), byte(p.Scale), byte(p.Hashing))
+}
+
+// readHeader(io.Reader, byte): read the header and check whether it is the header of kind. io.EOF is returned if r is empty
+func readHeader(r io.Reader, kind byte) (header, error) {
+	h, err := readHeaderKind(r)
+	if err == nil && h.kind != kind {
+		return h, malformed("unexpected kind %d (want %d)", h.kind, kind)
+	}
+	return h, err
+}
+
+// readHeaderKind(io.Reader): read the header of any kind. io.EOF is returned if r is empty
+func readHeaderKind(r io.Reader) (header, error) {
+	var (
+		h   header
+		hdr [headerSize]byte
+	)
+	if _, err := io.ReadFull(r, hdr[:6]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return h, malformed("truncated header")
+		}
+		return h, err
+	}
+	if string(hdr[:4]) != formatMagic {
+		return h, malformed("bad magic %q", hdr[:4])
+	}
+	if hdr[4] < legacyVersion || hdr[4] > formatVersion {
+		return h, malformed("unsupported version %d", hdr[4])
+	}
+	size := headerSize
+	if hdr[4] == legacyVersion {
+		size--
+		hdr[size] = byte(HashBigInt)
+	}
+	if _, err := io.ReadFull(r, hdr[6:size]); err != nil {
+		return h, malformed("truncated header")
+	}
+	h.kind, h.version = hdr[5], hdr[4]
+	h.params = Params{
+		BlockSize:    int(hdr[6]),
+		SubIndexSize: int(hdr[7]),
+		DomainBits:   int(hdr[8]),
+		Encoding:     Encoding(hdr[9]),
+		Scale:        int(hdr[10]),
+		Hashing:      Hashing(hdr[11]),
+	}
+	if err := h.params.Validate(); err != nil {
+		return h, malformed("%v", err)
+	}
+	return h, nil
+}
+
+// maxItemLen(): the upper bound of the length of one item body (used to reject the absurd length prefixes before allocating)
+func (p Params) maxItemLen() uint64 {
+	blockLen := p.SubIndexSize*binary.MaxVarintLen64 + p.cipherNum() + p.cipherNum()*(1+sha256.Size)
+	attrLen := binary.MaxVarintLen64 + gammaSize + p.blockNum()*blockLen
+	return uint64(2*binary.MaxVarintLen64 + len(KeyID{}) + maxPayloadSize + maxAttributes*attrLen)
+}
+
+// appendItemBody([]byte): append the body of the item to b
+func (item *IndexCipher) appendItemBody(b []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(item.attributes[0].gamma)))
+	b = append(b, item.attributes[0].gamma...)
+	b = append(b, item.keyID[:]...)
+	b = binary.AppendUvarint(b, uint64(len(item.payload)))
+	b = append(b, item.payload...)
+	b = item.attributes[0].appendBlocks(b)
+	b = binary.AppendUvarint(b, uint64(len(item.attributes)-1)) // the other attributes of a record
+	for _, attr := range item.attributes[1:] {
+		b = binary.AppendUvarint(b, uint64(len(attr.gamma)))
+		b = append(b, attr.gamma...)
+		b = attr.appendBlocks(b)
+	}
+	return b
+}
+
+// appendBlocks([]byte): append the blocks of the attribute to b
+func (attr *IndexAttributeCipher) appendBlocks(b []byte) []byte {
+	for j := range attr.blockCipher {
+		for _, list := range attr.blockCipher[j].subIndex {
+			b = binary.AppendUvarint(b, uint64(len(list)))
+			b = append(b, list...)
+		}
+		for _, c := range attr.blockCipher[j].ciphers {
+			b = binary.AppendUvarint(b, uint64(len(c)))
+			b = append(b, c...)
+		}
+	}
+	return b
+}
+
+// readBytes(*bytes.Reader, int): read a length-prefixed byte slice whose length is want (any length up to -want if want is negative)
+func readBytes(r *bytes.Reader, want int) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, malformed("truncated length")
+	}
+	if (want >= 0 && n != uint64(want)) || (want < 0 && (n == 0 || n > uint64(-want))) {
+		return nil, malformed("bad length %d", n)
+	}
+	if n > uint64(r.Len()) {
+		return nil, malformed("truncated data")
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return b, nil
+}
+
+// decodeItemBody(header, []byte): decode one item body of the version and the parameters in h. every field is validated and the body must be consumed exactly
+func decodeItemBody(h header, data []byte) (IndexCipher, error) {
+	var (
+		item IndexCipher
+		err  error
+		r    = bytes.NewReader(data)
+		p    = h.params
+	)
+	item.params = p
+	item.attributes = make([]IndexAttributeCipher, 1)
+	if item.attributes[0].gamma, err = readBytes(r, gammaSize); err != nil {
+		return item, err
+	}
+	if _, err := io.ReadFull(r, item.keyID[:]); err != nil {
+		return item, malformed("truncated key ID")
+	}
+	if item.payload, err = readBytes(r, -maxPayloadSize); err != nil {
+		return item, err
+	}
+	if item.attributes[0].blockCipher, err = readBlocks(r, p); err != nil {
+		return item, err
+	}
+	if h.version >= countVersion || r.Len() > 0 { // the other attributes of a record (before countVersion, only a record has the count)
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n >= uint64(maxAttributes) || (n == 0 && h.version < countVersion) {
+			return item, malformed("bad attribute count")
+		}
+		for a := uint64(0); a < n; a++ {
+			var attr IndexAttributeCipher
+			if attr.gamma, err = readBytes(r, gammaSize); err != nil {
+				return item, err
+			}
+			if attr.blockCipher, err = readBlocks(r, p); err != nil {
+				return item, err
+			}
+			item.attributes = append(item.attributes, attr)
+		}
+	}
+	if r.Len() != 0 {
+		return item, malformed("%d trailing bytes in item", r.Len())
+	}
+	return item, nil
+}
+
+// readBlocks(*bytes.Reader, Params): read the blocks of one attribute encrypted with the parameters p
+func readBlocks(r *bytes.Reader, p Params) ([]IndexBlockCipher, error) {
+	var err error
+	blocks := make([]IndexBlockCipher, p.blockNum())
+	for j := range blocks {
+		block := &blocks[j]
+		block.subIndex = make([][]uint8, p.SubIndexSize)
+		seen := make([]bool, p.cipherNum()) // every ciphertext must be in exactly one sub-index list
+		for a := range block.subIndex {
+			n, err := binary.ReadUvarint(r)
+			if err != nil || n > uint64(p.cipherNum()) || n > uint64(r.Len()) {
+				return nil, malformed("bad sub-index list in block %d", j)
+			}
+			if n > 0 {
+				block.subIndex[a] = make([]uint8, n)
+				r.Read(block.subIndex[a])
+			}
+			for _, pos := range block.subIndex[a] {
+				if int(pos) >= p.cipherNum() || seen[pos] {
+					return nil, malformed("bad sub-index position %d in block %d", pos, j)
+				}
+				seen[pos] = true
+			}
+		}
+		for _, ok := range seen {
+			if !ok {
+				return nil, malformed("incomplete sub-index lists in block %d", j)
+			}
+		}
+		block.ciphers = make([][]byte, p.cipherNum())
+		for c := range block.ciphers {
+			if block.ciphers[c], err = readBytes(r, sha256.Size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return blocks, nil
+}
+
+// byteReader(io.Reader): return r if it can read bytes, otherwise buffer it
+func byteReader(r io.Reader) interface {
+	io.Reader
+	io.ByteReader
+} {
+	if br, ok := r.(interface {
+		io.Reader
+		io.ByteReader
+	}); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
+
+// readItem(io.Reader, header): read one length-prefixed item body of the encoding with the header h. io.EOF is returned if r ends before the item
+func readItem(r interface {
+	io.Reader
+	io.ByteReader
+}, h header) (IndexCipher, error) {
+	p := h.params
+	n, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return IndexCipher{}, io.EOF
+	} else if err != nil {
+		return IndexCipher{}, malformed("truncated item length")
+	}
+	if n == 0 || n > p.maxItemLen() {
+		return IndexCipher{}, malformed("bad item length %d", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return IndexCipher{}, malformed("truncated item")
+	}
+	return decodeItemBody(h, data)
+}
+
+// MarshalBinary(): encode one index item (with the header)
+func (item *IndexCipher) MarshalBinary() ([]byte, error) {
+	if err := item.params.Validate(); err != nil {
+		return nil, err
+	}
+	return item.appendItemBody(appendHeader(nil, kindItem, item.params)), nil
+}
+
+// UnmarshalBinary([]byte): decode one index item encoded by MarshalBinary
+func (item *IndexCipher) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	h, err := readHeader(r, kindItem)
+	if err == io.EOF {
+		return malformed("empty item")
+	} else if err != nil {
+		return err
+	}
+	decoded, err := decodeItemBody(h, data[len(data)-r.Len():])
+	if err != nil {
+		return err
+	}
+	*item = decoded
+	return nil
+}
+
+// WriteTo(io.Writer): write the whole index to w (the deleted items are left out)
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	bw := bufio.NewWriter(w)
+	buf := appendHeader(nil, kindIndex, idx.params)
+	buf = binary.AppendUvarint(buf, uint64(idx.nextID))
+	buf = binary.AppendUvarint(buf, uint64(len(idx.slots)))
+	written, err := bw.Write(buf)
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+	for i := range idx.items {
+		if idx.deleted(i) {
+			continue
+		}
+		buf = idx.items[i].appendItemBody(buf[:0])
+		prefix := binary.AppendUvarint(nil, uint64(idx.ids[i]))
+		written, err = bw.Write(binary.AppendUvarint(prefix, uint64(len(buf))))
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+		written, err = bw.Write(buf)
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, bw.Flush()
+}
+
+// ReadIndex(io.Reader): read a whole index written by WriteTo
+func ReadIndex(r io.Reader) (*Index, error) {
+	br := byteReader(r)
+	h, err := readHeader(br, kindIndex)
+	if err == io.EOF {
+		return nil, malformed("empty index")
+	} else if err != nil {
+		return nil, err
+	}
+	nextID, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, malformed("truncated next item ID")
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, malformed("truncated item count")
+	}
+	if n > nextID {
+		return nil, malformed("%d items with the next ID %d", n, nextID)
+	}
+	idx := newIndex(h.params)
+	for i := uint64(0); i < n; i++ {
+		id, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, malformed("%d of %d items", i, n)
+		}
+		if ItemID(id) < idx.nextID || id >= nextID {
+			return nil, malformed("item ID %d out of order", id)
+		}
+		item, err := readItem(br, h)
+		if err == io.EOF {
+			return nil, malformed("%d of %d items", i, n)
+		} else if err != nil {
+			return nil, err
+		}
+		idx.add(ItemID(id), item)
+	}
+	idx.nextID = ItemID(nextID)
+	return idx, nil
+}
+
+// MarshalBinary(): encode the whole index
+func (idx *Index) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := idx.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary([]byte): decode a whole index encoded by MarshalBinary
+func (idx *Index) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	decoded, err := ReadIndex(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return malformed("%d trailing bytes in index", r.Len())
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.params, idx.items, idx.ids, idx.slots, idx.nextID = decoded.params, decoded.items, decoded.ids, decoded.slots, decoded.nextID
+	return nil
+}
+
+// Encoder: write a stream of index items (e.g. the readings sent by an IoT device one by one)
+type Encoder struct {
+	w      io.Writer // the destination
+	params Params    // the parameters of the items in the stream
+	header bool      // whether the header has been written
+}
+
+// NewEncoder(io.Writer, Params): create an Encoder for the items encrypted with the parameters p
+func NewEncoder(w io.Writer, p Params) *Encoder {
+	return &Encoder{w: w, params: p}
+}
+
+// Encode(*IndexCipher): write one item to the stream (the header is written before the first item)
+func (e *Encoder) Encode(item *IndexCipher) error {
+	if item.params != e.params {
+		return mismatch(item.params, e.params)
+	}
+	var buf []byte
+	if !e.header {
+		buf = appendHeader(buf, kindStream, e.params)
+	}
+	body := item.appendItemBody(nil)
+	buf = binary.AppendUvarint(buf, uint64(len(body)))
+	if _, err := e.w.Write(append(buf, body...)); err != nil {
+		return err
+	}
+	e.header = true
+	return nil
+}
+
+// Decoder: read a stream of index items written by Encoder
+type Decoder struct {
+	r interface {
+		io.Reader
+		io.ByteReader
+	} // the source
+	hdr  header // the header of the stream
+	read bool   // whether the header has been read
+}
+
+// NewDecoder(io.Reader): create a Decoder which reads from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: byteReader(r)}
+}
+
+// Params(): read the header (if not yet) and return the parameters of the stream. io.EOF is returned if the stream is empty (i.e. no item has been encoded)
+func (d *Decoder) Params() (Params, error) {
+	if !d.read {
+		h, err := readHeader(d.r, kindStream)
+		if err != nil {
+			return h.params, err
+		}
+		d.hdr, d.read = h, true
+	}
+	return d.hdr.params, nil
+}
+
+// Decode(): read the next item from the stream. io.EOF is returned at the end of the stream
+func (d *Decoder) Decode() (IndexCipher, error) {
+	if _, err := d.Params(); err != nil {
+		return IndexCipher{}, err
+	}
+	return readItem(d.r, d.hdr)
+}
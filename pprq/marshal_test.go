@@ -0,0 +1,349 @@
+package pprq
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newMarshalIndex(*testing.T, *Scheme): create an index of a few values with a deleted item, so the IDs have a gap
+func newMarshalIndex(t *testing.T, s *Scheme) *Index {
+	t.Helper()
+	idx, err := s.IndexEnc([]uint64{5, 10, 15, 20, 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	s := newTestScheme(t, DefaultParams())
+	idx := newMarshalIndex(t, s)
+	q, err := s.QueryEncRanges(Range{Lower: 0, Upper: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := idx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Index
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Params() != idx.Params() || !slices.Equal(decoded.IDs(), idx.IDs()) {
+		t.Fatalf("got %+v %v, want %+v %v", decoded.Params(), decoded.IDs(), idx.Params(), idx.IDs())
+	}
+	want, err := idx.Search(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decoded.Search(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(got, want, bytes.Equal) {
+		t.Errorf("search of the decoded index: got %d payloads, want %d", len(got), len(want))
+	}
+	// the next ID survives the round trip, so a new item does not reuse the ID of a deleted one
+	item, err := s.IndexItemEnc(30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := decoded.Append(item); err != nil || id != 5 {
+		t.Errorf("append after the round trip: got ID %d, %v, want 5", id, err)
+	}
+
+	// one item
+	ib, err := item.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decodedItem IndexCipher
+	if err := decodedItem.UnmarshalBinary(ib); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := decodedItem.MarshalBinary(); err != nil || !bytes.Equal(again, ib) {
+		t.Errorf("item round trip: %v", err)
+	}
+
+	// a stream of items
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, s.Params())
+	for _, v := range []uint64{1, 2, 3} {
+		item, err := s.IndexItemEnc(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(&item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dec := NewDecoder(&buf)
+	if p, err := dec.Params(); err != nil || p != s.Params() {
+		t.Fatalf("stream params: got %+v, %v", p, err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := dec.Decode(); err != nil {
+			t.Fatalf("item %d: %v", i, err)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("end of stream: got %v, want io.EOF", err)
+	}
+	if _, err := NewDecoder(bytes.NewReader(nil)).Params(); err != io.EOF {
+		t.Errorf("empty stream: got %v, want io.EOF", err)
+	}
+}
+
+// readTestdata(*testing.T, string): read a file of testdata
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestUnmarshalLegacy: the index, the token and the key in testdata were written by format version 4 (before the Hashing byte) with the parameters {2 3 16}. the index holds 50, 100, 150, 199, 200, 201, 250, 0, 65535, 120 with the item 2 deleted, and the token is [100,200]
+func TestUnmarshalLegacy(t *testing.T) {
+	ib, qb := readTestdata(t, "v4.idx"), readTestdata(t, "v4.tok")
+	if ib[4] != legacyVersion || qb[4] != legacyVersion {
+		t.Fatalf("the fixtures have versions %d and %d, want %d", ib[4], qb[4], legacyVersion)
+	}
+	var idx Index
+	if err := idx.UnmarshalBinary(ib); err != nil {
+		t.Fatal(err)
+	}
+	want := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 16, Hashing: HashBigInt}
+	if idx.Params() != want {
+		t.Fatalf("params %+v, want %+v", idx.Params(), want)
+	}
+	if ids := idx.IDs(); !slices.Equal(ids, []ItemID{0, 1, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Fatalf("IDs %v", ids)
+	}
+	var q QueryToken
+	if err := q.UnmarshalBinary(qb); err != nil {
+		t.Fatal(err)
+	}
+	matches := []ItemID{1, 3, 4, 9}
+	checkToken(t, &idx, &q, matches)
+
+	// the owner still opens the payloads and queries the legacy items with new tokens
+	var key Key
+	if err := key.UnmarshalBinary(readTestdata(t, "v4.key")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSchemeWithKey(&key, idx.Params())
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := s.PlainValues(&idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[ItemID]uint64{0: 50, 1: 100, 3: 199, 4: 200, 5: 201, 6: 250, 7: 0, 8: 65535, 9: 120}; !maps.Equal(values, want) {
+		t.Fatalf("values %v, want %v", values, want)
+	}
+	fresh, err := s.QueryEncRanges(Range{Lower: 100, Upper: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkToken(t, &idx, fresh, matches)
+
+	// the legacy index is written back in the current version and keeps its hashing
+	b, err := idx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again Index
+	if err := again.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if b[4] != formatVersion || again.Params() != want {
+		t.Fatalf("version %d, params %+v", b[4], again.Params())
+	}
+	checkToken(t, &again, &q, matches)
+}
+
+func TestUnmarshalMalformed(t *testing.T) {
+	s := newTestScheme(t, DefaultParams())
+	idx := newMarshalIndex(t, s)
+	ib, err := idx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := idx.Item(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := item.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// modify(...): copy the item encoding and change it
+	modify := func(data []byte, f func([]byte) []byte) []byte {
+		return f(slices.Clone(data))
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", modify(b, func(d []byte) []byte { d[0] = 'X'; return d })},
+		{"old version", modify(b, func(d []byte) []byte { d[4] = legacyVersion - 1; return d })},
+		{"future version", modify(b, func(d []byte) []byte { d[4] = formatVersion + 1; return d })},
+		{"index kind", modify(b, func(d []byte) []byte { d[5] = kindIndex; return d })},
+		{"invalid params", modify(b, func(d []byte) []byte { d[6] = byte(maxBlockSize + 1); return d })},
+		{"other params", modify(b, func(d []byte) []byte { d[7] = 1; return d })}, // the sub-index positions do not fit
+		{"trailing bytes", append(slices.Clone(b), 0)},
+		{"bad gamma length", modify(b, func(d []byte) []byte { d[headerSize] = 0; return d })},
+	}
+	for n := 1; n < len(b); n++ {
+		cases = append(cases, struct {
+			name string
+			data []byte
+		}{"truncated", b[:n]})
+	}
+	for _, c := range cases {
+		var decoded IndexCipher
+		if err := decoded.UnmarshalBinary(c.data); !errors.Is(err, ErrMalformedIndex) {
+			t.Errorf("item %s (%d bytes): got %v, want ErrMalformedIndex", c.name, len(c.data), err)
+		}
+	}
+
+	// the whole index: every truncation, the trailing bytes and the item encoding are rejected
+	for n := 0; n < len(ib); n++ {
+		var decoded Index
+		if err := decoded.UnmarshalBinary(ib[:n]); !errors.Is(err, ErrMalformedIndex) {
+			t.Errorf("index truncated to %d bytes: got %v, want ErrMalformedIndex", n, err)
+		}
+	}
+	var decoded Index
+	if err := decoded.UnmarshalBinary(append(slices.Clone(ib), 0)); !errors.Is(err, ErrMalformedIndex) {
+		t.Errorf("index with trailing bytes: got %v, want ErrMalformedIndex", err)
+	}
+	if err := decoded.UnmarshalBinary(b); !errors.Is(err, ErrMalformedIndex) {
+		t.Errorf("item as index: got %v, want ErrMalformedIndex", err)
+	}
+}
+
+func TestUnmarshalRecord(t *testing.T) {
+	s := newTestScheme(t, DefaultParams())
+	record, err := s.IndexRecordEnc(100, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := record.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := record
+	head.attributes = head.attributes[:1]
+	single, err := head.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single[len(single)-1] != 0 {
+		t.Fatalf("the item of a single value ends with %d, want the attribute count 0", single[len(single)-1])
+	}
+
+	// the record cut after the blocks of attribute 0 is not taken for the item of a single value
+	boundary := len(single) - 1
+	var decoded IndexCipher
+	if err := decoded.UnmarshalBinary(b[:boundary]); !errors.Is(err, ErrMalformedIndex) {
+		t.Errorf("record truncated before its other attributes: got %v, want ErrMalformedIndex", err)
+	}
+	for n := 1; n < len(b); n++ {
+		if err := decoded.UnmarshalBinary(b[:n]); !errors.Is(err, ErrMalformedIndex) {
+			t.Errorf("record truncated to %d bytes: got %v, want ErrMalformedIndex", n, err)
+		}
+	}
+	if err := decoded.UnmarshalBinary(b); err != nil || len(decoded.attributes) != 2 {
+		t.Fatalf("record: %d attributes, %v", len(decoded.attributes), err)
+	}
+
+	// before countVersion, the item of a single value has no count and a record has a count above 0
+	legacy := slices.Clone(single[:boundary])
+	legacy[4] = countVersion - 1
+	if err := decoded.UnmarshalBinary(legacy); err != nil || len(decoded.attributes) != 1 {
+		t.Errorf("single value of version %d: %d attributes, %v", legacy[4], len(decoded.attributes), err)
+	}
+	legacy = slices.Clone(b)
+	legacy[4] = countVersion - 1
+	if err := decoded.UnmarshalBinary(legacy); err != nil || len(decoded.attributes) != 2 {
+		t.Errorf("record of version %d: %d attributes, %v", legacy[4], len(decoded.attributes), err)
+	}
+	legacy = slices.Clone(single)
+	legacy[4] = countVersion - 1
+	if err := decoded.UnmarshalBinary(legacy); !errors.Is(err, ErrMalformedIndex) {
+		t.Errorf("count 0 in version %d: got %v, want ErrMalformedIndex", legacy[4], err)
+	}
+}
+
+func TestUnmarshalShortGamma(t *testing.T) {
+	s := newTestScheme(t, DefaultParams())
+	record, err := s.IndexRecordEnc(100, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a gamma of every attribute shorter than gammaSize is rejected (the revision of an item is read from its first bytes)
+	for a := range record.attributes {
+		for _, n := range []int{1, 4, gammaSize - 1} {
+			short := record
+			short.attributes = slices.Clone(record.attributes)
+			short.attributes[a].gamma = short.attributes[a].gamma[:n]
+			b, err := short.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded IndexCipher
+			if err := decoded.UnmarshalBinary(b); !errors.Is(err, ErrMalformedIndex) {
+				t.Errorf("gamma of %d bytes in attribute %d: got %v, want ErrMalformedIndex", n, a, err)
+			}
+		}
+	}
+}
+
+func TestMarshalParamMismatch(t *testing.T) {
+	s := newTestScheme(t, DefaultParams())
+	other := newTestScheme(t, Params{BlockSize: 4, SubIndexSize: 15, DomainBits: 32})
+	item, err := other.IndexItemEnc(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the items of other parameters are rejected by the stream and by the index
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf, s.Params()).Encode(&item); !errors.Is(err, ErrParamMismatch) {
+		t.Errorf("Encode: got %v, want ErrParamMismatch", err)
+	}
+	idx := newMarshalIndex(t, s)
+	if _, err := idx.Append(item); !errors.Is(err, ErrParamMismatch) {
+		t.Errorf("Append: got %v, want ErrParamMismatch", err)
+	}
+
+	// a decoded item keeps the parameters of its header
+	b, err := item.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded IndexCipher
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Append(decoded); !errors.Is(err, ErrParamMismatch) {
+		t.Errorf("Append of the decoded item: got %v, want ErrParamMismatch", err)
+	}
+}
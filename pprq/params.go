@@ -0,0 +1,86 @@
+package pprq
+
+import "math"
+
+// Params: the public parameters of the scheme. They are chosen at key-generation time and shared by the index and the queries
+type Params struct {
+	BlockSize    int      // the number of bits in one block (1 to 8)
+	SubIndexSize int      // the size of subIndex, i.e. the number of sub-index types in one block (1 to 2^{BlockSize}-1)
+	DomainBits   int      // the number of bits of one value, i.e. the values are in [0, 2^{DomainBits}-1] (8 to 64)
+	Encoding     Encoding // the order-preserving encoding which maps the typed values into the domain (see encoding.go)
+	Scale        int      // the number of decimal digits kept by FixedPoint (0 to 18)
+	Hashing      Hashing  // how the digests are turned into the sub-indexes and the ciphers (see hashing.go)
+}
+
+const (
+	maxBlockSize      int = 8  // the max number of bits in one block (the positions of ciphers are stored in uint8)
+	defaultBlockLen   int = 2  // the default number of bits in one block
+	minDomainBits     int = 8  // the min number of bits of one value
+	maxDomainBits     int = 64 // the max number of bits of one value
+	defaultDomainBits int = 32 // the default number of bits of one value
+)
+
+// DefaultParams(): return the parameters of the 2-bit version (the default version in the paper)
+func DefaultParams() Params {
+	return Params{
+		BlockSize:    defaultBlockLen,
+		SubIndexSize: 1<<defaultBlockLen - 1,
+		DomainBits:   defaultDomainBits,
+	}
+}
+
+// Validate(): check whether the parameters are supported
+func (p Params) Validate() error {
+	if p.BlockSize < 1 || p.BlockSize > maxBlockSize {
+		return wrapError(ErrInvalidParams, "block size %d out of range [1,%d]", p.BlockSize, maxBlockSize)
+	}
+	if p.SubIndexSize < 1 || p.SubIndexSize > p.cipherNum() {
+		return wrapError(ErrInvalidParams, "sub-index size %d out of range [1,%d]", p.SubIndexSize, p.cipherNum())
+	}
+	if p.DomainBits < minDomainBits || p.DomainBits > maxDomainBits {
+		return wrapError(ErrInvalidParams, "domain bits %d out of range [%d,%d]", p.DomainBits, minDomainBits, maxDomainBits)
+	}
+	if err := p.validateEncoding(); err != nil {
+		return err
+	}
+	return p.validateHashing()
+}
+
+// MaxValue(): return the max value in the domain (i.e. 2^{DomainBits}-1)
+func (p Params) MaxValue() uint64 {
+	return math.MaxUint64 >> (maxDomainBits - p.DomainBits)
+}
+
+// checkValue(uint64): check whether v is in the domain
+func (p Params) checkValue(v uint64) error {
+	if v > p.MaxValue() {
+		return wrapError(ErrValueOutOfDomain, "%d not in [0,%d]", v, p.MaxValue())
+	}
+	return nil
+}
+
+// blockPossValue(): the possible maximum value in one block (i.e. 2^{blockSize})
+func (p Params) blockPossValue() int64 {
+	return 1 << p.BlockSize
+}
+
+// cipherNum(): the number of ciphers in one block in index (i.e. 2^{blockSize}-1)
+func (p Params) cipherNum() int {
+	return 1<<p.BlockSize - 1
+}
+
+// blockNum(): the number of blocks in one value. the value is padded with zeros to a multiple of blockSize
+func (p Params) blockNum() int {
+	return (p.DomainBits + p.BlockSize - 1) / p.BlockSize
+}
+
+// splitBlock(uint64, int): get the i-th block of v and its prefix (i.e. the value of all the previous blocks). the first block has no prefix (0 is returned)
+func (p Params) splitBlock(v uint64, i int) (block int64, prefix uint64) {
+	// the value is padded with zeros to paddedBits, which may exceed 64 bits. the shifts below are always less than 64 since (i+1)*blockSize >= 1 and i*blockSize >= blockSize for the other blocks
+	paddedBits := p.blockNum() * p.BlockSize
+	block = int64(v>>(paddedBits-(i+1)*p.BlockSize)) & (p.blockPossValue() - 1)
+	if i > 0 { // other (has prefix)
+		prefix = v >> (paddedBits - i*p.BlockSize)
+	}
+	return block, prefix
+}
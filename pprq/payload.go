@@ -0,0 +1,143 @@
+package pprq
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+/*
+	The format of the payload attached to one index item (all the integers are unsigned varints unless noted):
+
+	sealed:    key ID (8 bytes) | nonce (12 bytes) | AES-256-GCM(plaintext) with the key ID as the additional data
+	plaintext: version (1 byte) | Value | [len(Attributes) | Attributes (version 2 only)] | len(DeviceID) | DeviceID | Timestamp (signed varint) | len(Extra) | Extra
+
+	The payload of a single value is written in version 1, and the payload of a record in version 2.
+
+	The AES key is derived from the HMAC key with HKDF-SHA256, so the fog node (which never has the key) only handles the sealed form.
+*/
+
+// Payload: the reading and its metadata attached to one index item. only the holder of the key can read it
+type Payload struct {
+	Value      uint64   // the encoded reading (see Params.EncodeInt and Params.EncodeFloat), which is also the value encrypted in the index (attribute 0 of a record)
+	Attributes []uint64 // the other encoded attributes of a record, also encrypted in the index (see record.go)
+	DeviceID   string   // the device which produced the reading
+	Timestamp  int64    // the time of the reading (Unix milliseconds, 0: unknown)
+	Extra      []byte   // the application-defined metadata
+}
+
+const (
+	payloadVersion byte   = 1              // the version of the plaintext format of a single value
+	recordVersion  byte   = 2              // the version of the plaintext format of a record
+	payloadInfo    string = "pprq payload" // the HKDF info of the payload key
+	maxPayloadSize int    = 64 << 10       // the max length of one sealed payload
+)
+
+// newPayloadAEAD(*Key): derive the AES-GCM instance of the payloads from the key
+func newPayloadAEAD(k *Key) (cipher.AEAD, error) {
+	aesKey, err := hkdf.Key(sha256.New, k.material, nil, payloadInfo, 32)
+	if err != nil {
+		return nil, wrapError(ErrInvalidKey, "%v", err)
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealPayload(*Payload): encrypt the payload with the key of the scheme
+func (s *Scheme) sealPayload(pl *Payload) ([]byte, error) {
+	plaintext := []byte{payloadVersion}
+	if len(pl.Attributes) > 0 {
+		plaintext[0] = recordVersion
+	}
+	plaintext = binary.AppendUvarint(plaintext, pl.Value)
+	if len(pl.Attributes) > 0 {
+		plaintext = binary.AppendUvarint(plaintext, uint64(len(pl.Attributes)))
+		for _, v := range pl.Attributes {
+			plaintext = binary.AppendUvarint(plaintext, v)
+		}
+	}
+	plaintext = binary.AppendUvarint(plaintext, uint64(len(pl.DeviceID)))
+	plaintext = append(plaintext, pl.DeviceID...)
+	plaintext = binary.AppendVarint(plaintext, pl.Timestamp)
+	plaintext = binary.AppendUvarint(plaintext, uint64(len(pl.Extra)))
+	plaintext = append(plaintext, pl.Extra...)
+	overhead := len(s.key.id) + s.payload.NonceSize() + s.payload.Overhead()
+	if len(plaintext)+overhead > maxPayloadSize {
+		return nil, wrapError(ErrInvalidPayload, "payload of %d bytes exceeds %d bytes", len(plaintext)+overhead, maxPayloadSize)
+	}
+
+	sealed := make([]byte, len(s.key.id)+s.payload.NonceSize(), len(plaintext)+overhead)
+	copy(sealed, s.key.id[:])
+	nonce := sealed[len(s.key.id):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, wrapError(ErrRandomness, "%v", err)
+	}
+	return s.payload.Seal(sealed, nonce, plaintext, s.key.id[:]), nil
+}
+
+// OpenPayload([]byte): decrypt a payload returned by the fog node (see Index.Search and IndexCipher.Payload). the payload must be sealed with the key of the scheme
+func (s *Scheme) OpenPayload(sealed []byte) (Payload, error) {
+	var pl Payload
+	idSize, nonceSize := len(s.key.id), s.payload.NonceSize()
+	if len(sealed) < idSize+nonceSize+s.payload.Overhead() {
+		return pl, wrapError(ErrInvalidPayload, "truncated payload")
+	}
+	if !bytes.Equal(sealed[:idSize], s.key.id[:]) {
+		return pl, wrapError(ErrInvalidKey, "payload sealed with key %x (have %s)", sealed[:idSize], s.key.id)
+	}
+	plaintext, err := s.payload.Open(nil, sealed[idSize:idSize+nonceSize], sealed[idSize+nonceSize:], sealed[:idSize])
+	if err != nil {
+		return pl, wrapError(ErrInvalidPayload, "authentication failed")
+	}
+
+	r := bytes.NewReader(plaintext)
+	version, _ := r.ReadByte()
+	if version != payloadVersion && version != recordVersion {
+		return pl, wrapError(ErrInvalidPayload, "unsupported version %d", version)
+	}
+	if pl.Value, err = binary.ReadUvarint(r); err != nil {
+		return pl, wrapError(ErrInvalidPayload, "truncated value")
+	}
+	if version == recordVersion {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n == 0 || n >= uint64(maxAttributes) {
+			return pl, wrapError(ErrInvalidPayload, "bad attribute count")
+		}
+		pl.Attributes = make([]uint64, n)
+		for a := range pl.Attributes {
+			if pl.Attributes[a], err = binary.ReadUvarint(r); err != nil {
+				return pl, wrapError(ErrInvalidPayload, "truncated attribute")
+			}
+		}
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return pl, wrapError(ErrInvalidPayload, "bad device ID")
+	}
+	deviceID := make([]byte, n)
+	r.Read(deviceID)
+	pl.DeviceID = string(deviceID)
+	if pl.Timestamp, err = binary.ReadVarint(r); err != nil {
+		return pl, wrapError(ErrInvalidPayload, "truncated timestamp")
+	}
+	if n, err = binary.ReadUvarint(r); err != nil || n != uint64(r.Len()) {
+		return pl, wrapError(ErrInvalidPayload, "bad extra metadata")
+	}
+	if n > 0 {
+		pl.Extra = make([]byte, n)
+		r.Read(pl.Extra)
+	}
+	return pl, nil
+}
+
+// Payload(): return the sealed payload of the item
+func (item *IndexCipher) Payload() []byte {
+	return item.payload
+}
@@ -0,0 +1,479 @@
+/*
+	pprq.go
+	By Hongcheng Xie at Department of Computer Science, City University of Hong Kong, Hong Kong SAR, China
+
+	Package pprq is the core of the privacy-preserving range query scheme shared by the PC prototype (PC/) and the IoT prototype (iot/).
+	The data owner holds a Scheme (the secret key) and uses it to encrypt an Index and QueryTokens. Search only needs the Index and a QueryToken, so it can be run by the fog node.
+	The block size (the 2-bit version is the default) and the sub-index size are chosen in Params when the Scheme is created.
+*/
+package pprq
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"math"
+	"slices"
+	"sync"
+)
+
+// the structure of the ciphertext of one block in query
+type QueryBlockCipher struct {
+	subIndex uint8  // the subIndex to optimize the searching performance
+	cipher   []byte // the actual ciphertext
+}
+
+// the structure of one bound of the range [a,b]. an unbounded side (a = 0 or b = the max value) has no block cipher and matches every item
+type QueryRangeCipher struct {
+	blockCipher []QueryBlockCipher // the set of each block's cipher
+}
+
+// the structure of one interval [a,b] of the query
+type QueryIntervalCipher struct {
+	lower QueryRangeCipher // the lower bound
+	upper QueryRangeCipher // the upper bound
+}
+
+// the structure of the condition on one attribute of the records, i.e. the union of its intervals
+type QueryConditionCipher struct {
+	attribute int                   // the attribute (0: the value of a single-value item)
+	intervals []QueryIntervalCipher // the disjoint intervals in ascending order (none: the condition matches nothing)
+}
+
+// QueryToken: the structure of the whole query, i.e. the conjunction of its conditions
+type QueryToken struct {
+	params     Params                 // the parameters which the query is generated with
+	keyID      KeyID                  // the key which the query is generated with
+	conditions []QueryConditionCipher // the conditions, evaluated in order until one fails
+}
+
+// Range: one interval [Lower,Upper] of values in the domain, inclusive on both bounds
+type Range struct {
+	Lower uint64 // the lower bound
+	Upper uint64 // the upper bound
+}
+
+// the structure of one block in index
+type IndexBlockCipher struct {
+	// the sub-index list of one block [the number of sub-index types (each one is denoted as A)][the conflicts in one sub-index]. the content is the array index whose sub-index is A
+	subIndex [][]uint8
+	// the ciphertexts of one block
+	ciphers [][]byte
+}
+
+// the structure of one attribute of an item in index
+type IndexAttributeCipher struct {
+	gamma       []byte             // the nonce
+	blockCipher []IndexBlockCipher // the set of each block's cipher
+}
+
+// the structure of one item in index, i.e. a record of one or more attributes
+type IndexCipher struct {
+	params     Params                 // the parameters which the item is encrypted with
+	keyID      KeyID                  // the key which the item is encrypted with
+	attributes []IndexAttributeCipher // the attributes, each encrypted independently (one for a single value)
+	payload    []byte                 // the sealed payload (see payload.go)
+}
+
+// Scheme: the data owner's side of the scheme. It holds the HMAC key and generates the index items and the queries
+type Scheme struct {
+	params  Params      // the parameters of the scheme
+	key     *Key        // the HMAC key
+	payload cipher.AEAD // the cipher of the payloads, derived from key
+
+	attrKeys   [][]byte  // the HMAC keys of the attributes of the records (see record.go)
+	encryptors sync.Pool // the reusable encryption states (see engine.go)
+}
+
+const gammaSize int = 256 // the length of the nonce of one index item
+
+// NewScheme(Params): initialize the basic parameters and generate a fresh HMAC key
+func NewScheme(p Params) (*Scheme, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return NewSchemeWithKey(key, p)
+}
+
+// NewSchemeWithKey(*Key, Params): create a scheme with an existing key (e.g. the stored key of the data owner, or the key provisioned to the IoT devices)
+func NewSchemeWithKey(key *Key, p Params) (*Scheme, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, wrapError(ErrInvalidKey, "nil key")
+	}
+	aead, err := newPayloadAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	attrKeys, err := attributeKeys(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Scheme{params: p, key: key, payload: aead, attrKeys: attrKeys}, nil
+}
+
+// Key(): return the HMAC key (to be stored, or provisioned to the IoT devices)
+func (s *Scheme) Key() *Key {
+	return s.key
+}
+
+// F([]byte,[]byte): another hash function (v: the query cipher of one block)
+func F(v []byte, gamma []byte) []byte {
+	hmac_ins := hmac.New(sha256.New, gamma)
+	hmac_ins.Write(v)
+	return hmac_ins.Sum(nil)
+}
+
+// Params(): return the parameters of the scheme
+func (s *Scheme) Params() Params {
+	return s.params
+}
+
+// indexBlockEnc(*encryptor,int,int64,uint64,int,hash.Hash): encrypt one block of the attribute in index (gammaMAC: F keyed by the nonce of the attribute, i.e. HMAC(gamma, .))
+func (s *Scheme) indexBlockEnc(e *encryptor, attribute int, block int64, prefix uint64, blockId int, gammaMAC hash.Hash) IndexBlockCipher {
+	var (
+		ret       IndexBlockCipher
+		i         int64
+		cipherPos int = 0 // the next available space of ciphertext list
+	)
+	ret.subIndex = make([][]uint8, s.params.SubIndexSize)
+	ret.ciphers = make([][]byte, s.params.cipherNum())
+	slab := make([]byte, s.params.cipherNum()*sha256.Size) // the storage of all the ciphertexts of the block
+
+	for i = 0; i < s.params.blockPossValue(); i++ {
+		var operator byte
+		if i == block { // do not encrypt the equal block
+			continue
+		} else if i < block { // the current variable is smaller than the current block
+			operator = '>'
+		} else { // the current variable is larger than the current block
+			operator = '<'
+		}
+		exp := e.getHashedValue(attribute, i, operator, prefix, blockId) // get the hash value in power part of ciphertext
+
+		// append the position of the ciphertext to the list of its sub-index
+		subIndex := s.params.subIndexOf(&exp)
+		ret.subIndex[subIndex] = append(ret.subIndex[subIndex], uint8(cipherPos))
+
+		// generate the ciphertext
+		gammaMAC.Reset()
+		gammaMAC.Write(s.params.cipherOf(&exp))
+		ret.ciphers[cipherPos] = gammaMAC.Sum(slab[cipherPos*sha256.Size : cipherPos*sha256.Size : (cipherPos+1)*sha256.Size])
+		cipherPos++
+	}
+	return ret
+}
+
+// IndexItemEnc(uint64): encrypt one item in index (v: the value to be encrypted, in [0, 2^{DomainBits}-1]). the payload only holds the value
+func (s *Scheme) IndexItemEnc(v uint64) (IndexCipher, error) {
+	return s.IndexItemEncPayload(Payload{Value: v})
+}
+
+// IndexItemEncPayload(Payload): encrypt pl.Value (and pl.Attributes for a record) as one item in index and attach the sealed payload to it
+func (s *Scheme) IndexItemEncPayload(pl Payload) (IndexCipher, error) {
+	e := s.getEncryptor()
+	defer s.putEncryptor(e)
+	return s.indexItemEnc(e, &pl)
+}
+
+// indexItemEnc(*encryptor, *Payload): encrypt one item in index with the encryption state e
+func (s *Scheme) indexItemEnc(e *encryptor, pl *Payload) (IndexCipher, error) {
+	var (
+		item IndexCipher
+		err  error
+	)
+	if len(pl.Attributes)+1 > maxAttributes {
+		return item, wrapError(ErrInvalidParams, "%d attributes (max %d)", len(pl.Attributes)+1, maxAttributes)
+	}
+	values := append([]uint64{pl.Value}, pl.Attributes...)
+	for _, v := range values {
+		if err := s.params.checkValue(v); err != nil {
+			return item, err
+		}
+	}
+	if item.payload, err = s.sealPayload(pl); err != nil {
+		return IndexCipher{}, err
+	}
+
+	item.params = s.params
+	item.keyID = s.key.id
+	item.attributes = make([]IndexAttributeCipher, len(values))
+	for a, v := range values {
+		if item.attributes[a], err = s.indexAttributeEnc(e, a, v); err != nil {
+			return IndexCipher{}, err
+		}
+	}
+	return item, nil
+}
+
+// indexAttributeEnc(*encryptor, int, uint64): encrypt the value of one attribute with a fresh nonce
+func (s *Scheme) indexAttributeEnc(e *encryptor, attribute int, v uint64) (IndexAttributeCipher, error) {
+	var attr IndexAttributeCipher
+	attr.gamma = make([]byte, gammaSize) // the nonce
+	if _, err := rand.Read(attr.gamma); err != nil {
+		return attr, wrapError(ErrRandomness, "%v", err)
+	}
+
+	gammaMAC := hmac.New(sha256.New, attr.gamma) // F with the nonce of the attribute, shared by all the blocks
+	attr.blockCipher = make([]IndexBlockCipher, s.params.blockNum())
+	for i := range attr.blockCipher {
+		block, prefix := s.params.splitBlock(v, i)                                      // the block contains blockSize bits
+		attr.blockCipher[i] = s.indexBlockEnc(e, attribute, block, prefix, i, gammaMAC) // encrypt the block
+	}
+	return attr, nil
+}
+
+// IndexItemEncInt(int64): encode an integer by the encoding of the scheme and encrypt it as one item in index
+func (s *Scheme) IndexItemEncInt(v int64) (IndexCipher, error) {
+	u, err := s.params.EncodeInt(v)
+	if err != nil {
+		return IndexCipher{}, err
+	}
+	return s.IndexItemEnc(u)
+}
+
+// IndexItemEncFloat(float64): encode a float by the encoding of the scheme and encrypt it as one item in index
+func (s *Scheme) IndexItemEncFloat(f float64) (IndexCipher, error) {
+	u, err := s.params.EncodeFloat(f)
+	if err != nil {
+		return IndexCipher{}, err
+	}
+	return s.IndexItemEnc(u)
+}
+
+// IndexEnc([]uint64): encrypt all the values as the index items in parallel (their IDs are 0, 1, ... in the order of values)
+func (s *Scheme) IndexEnc(values []uint64) (*Index, error) {
+	payloads := make([]Payload, len(values))
+	for i, v := range values {
+		payloads[i].Value = v
+	}
+	items, err := s.IndexItemsEnc(payloads, 0)
+	if err != nil {
+		return nil, err
+	}
+	idx := newIndex(s.params)
+	for _, item := range items {
+		idx.add(idx.nextID, item)
+	}
+	return idx, nil
+}
+
+// queryBlockEnc(*encryptor, int, int64, byte, uint64, int): generate the ciphertext for one block of the attribute (block and operator: the combination of block value and the operator)
+func (s *Scheme) queryBlockEnc(e *encryptor, attribute int, block int64, operator byte, prefix uint64, blockId int) QueryBlockCipher {
+	var ret QueryBlockCipher
+	exp := e.getHashedValue(attribute, block, operator, prefix, blockId) // get the hash value in power part of ciphertext
+	ret.subIndex = s.params.subIndexOf(&exp)                             // calculate the sub-index value (G_k mod subIndexSize)
+
+	// generate the ciphertext
+	ret.cipher = bytes.Clone(s.params.cipherOf(&exp))
+	return ret
+}
+
+// queryRangeEnc(*encryptor, int, uint64, bool): generate the ciphertext for one bound of the attribute. parameter bound is the value of one bound (exclusive, i.e. the lower bound matches the values larger than bound). parameter isLower defines whether bound is the lower bound or not(i.e. the upper bound)
+func (s *Scheme) queryRangeEnc(e *encryptor, attribute int, bound uint64, isLower bool) QueryRangeCipher {
+	var (
+		res      QueryRangeCipher
+		operator byte
+	)
+
+	// get the operator
+	if isLower == true { // if bound is the lower bound
+		operator = '>'
+	} else { // if bound is the upper bound
+		operator = '<'
+	}
+
+	res.blockCipher = make([]QueryBlockCipher, s.params.blockNum())
+	for i := range res.blockCipher {
+		block, prefix := s.params.splitBlock(bound, i) // the block contains blockSize bits
+		res.blockCipher[i] = s.queryBlockEnc(e, attribute, block, operator, prefix, i)
+	}
+	return res
+}
+
+// queryIntervalEnc(*encryptor, int, Range): generate the ciphertext of one interval of the condition on the attribute
+func (s *Scheme) queryIntervalEnc(e *encryptor, attribute int, r Range) QueryIntervalCipher {
+	var res QueryIntervalCipher
+	// the block comparison is strict, so the interval is encrypted as (Lower-1, Upper+1). the side which covers the end of the domain is left unbounded
+	if r.Lower > 0 {
+		res.lower = s.queryRangeEnc(e, attribute, r.Lower-1, true)
+	}
+	if r.Upper < s.params.MaxValue() {
+		res.upper = s.queryRangeEnc(e, attribute, r.Upper+1, false)
+	}
+	return res
+}
+
+// normalizeRanges([]Range): drop the empty ranges (Lower > Upper), then sort the others and merge the overlapping or adjacent ones, so every value is in at most one interval
+func normalizeRanges(ranges []Range) []Range {
+	var res []Range
+	for _, r := range ranges {
+		if r.Lower <= r.Upper {
+			res = append(res, r)
+		}
+	}
+	slices.SortFunc(res, func(a, b Range) int {
+		return cmp.Compare(a.Lower, b.Lower)
+	})
+	merged := res[:0]
+	for _, r := range res {
+		if n := len(merged); n > 0 && (merged[n-1].Upper == math.MaxUint64 || r.Lower <= merged[n-1].Upper+1) {
+			merged[n-1].Upper = max(merged[n-1].Upper, r.Upper)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// QueryEncRanges(...Range): generate the ciphertext of the union of the ranges (e.g. the readings below 5 or above 95), which the search evaluates in one pass over the index and returns each matched item once
+func (s *Scheme) QueryEncRanges(ranges ...Range) (*QueryToken, error) {
+	return s.QueryEncConjunction(Condition{Attribute: 0, Ranges: ranges})
+}
+
+// QueryEnc(uint64,uint64): generate the ciphertext of query (lowerBound,upperBound), i.e. the values strictly between the bounds as in the original prototype (QueryEncRanges, QueryEncInt and QueryEncFloat take the inclusive bounds). the query matches nothing if no value is between the bounds
+func (s *Scheme) QueryEnc(lowerBound uint64, upperBound uint64) (*QueryToken, error) {
+	if err := s.params.checkValue(lowerBound); err != nil {
+		return nil, err
+	}
+	if err := s.params.checkValue(upperBound); err != nil {
+		return nil, err
+	}
+	if lowerBound >= upperBound || upperBound-lowerBound < 2 { // no value between the bounds
+		return s.QueryEncRanges()
+	}
+	return s.QueryEncRanges(Range{Lower: lowerBound + 1, Upper: upperBound - 1})
+}
+
+// IntRange(int64,int64): encode the range [lowerBound,upperBound] of integers by the encoding of the parameters (e.g. for QueryEncRanges)
+func (p Params) IntRange(lowerBound int64, upperBound int64) (Range, error) {
+	lower, err := p.EncodeInt(lowerBound)
+	if err != nil {
+		return Range{}, err
+	}
+	upper, err := p.EncodeInt(upperBound)
+	return Range{Lower: lower, Upper: upper}, err
+}
+
+// FloatRange(float64,float64): encode the range [lowerBound,upperBound] of floats by the encoding of the parameters (e.g. for QueryEncRanges)
+func (p Params) FloatRange(lowerBound float64, upperBound float64) (Range, error) {
+	lower, err := p.EncodeFloat(lowerBound)
+	if err != nil {
+		return Range{}, err
+	}
+	upper, err := p.EncodeFloat(upperBound)
+	return Range{Lower: lower, Upper: upper}, err
+}
+
+// QueryEncInt(int64,int64): generate the ciphertext of query [lowerBound,upperBound] on integers encoded by the encoding of the scheme (both bounds are inclusive)
+func (s *Scheme) QueryEncInt(lowerBound int64, upperBound int64) (*QueryToken, error) {
+	r, err := s.params.IntRange(lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryEncRanges(r)
+}
+
+// QueryEncFloat(float64,float64): generate the ciphertext of query [lowerBound,upperBound] on floats encoded by the encoding of the scheme (e.g. [-20.0, 5.5], both bounds are inclusive)
+func (s *Scheme) QueryEncFloat(lowerBound float64, upperBound float64) (*QueryToken, error) {
+	r, err := s.params.FloatRange(lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryEncRanges(r)
+}
+
+// matchToken(*IndexCipher, *QueryToken, *SearchStats): check whether the index item satisfies every condition of the query. the scan stops at the first failed condition, and an item without the attribute of a condition never matches
+func matchToken(item *IndexCipher, q *QueryToken, stats *SearchStats) bool {
+	for i := range q.conditions {
+		c := &q.conditions[i]
+		if c.attribute >= len(item.attributes) || !matchCondition(&item.attributes[c.attribute], c, stats) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchCondition(*IndexAttributeCipher, *QueryConditionCipher, *SearchStats): check whether the attribute is in any interval of the condition. the intervals are in ascending order, so the scan stops at the first interval whose lower bound is above the attribute
+func matchCondition(attr *IndexAttributeCipher, c *QueryConditionCipher, stats *SearchStats) bool {
+	var hmac_ins hash.Hash // F keyed by the nonce of the attribute, created on the first use and shared by all the bounds
+	for i := range c.intervals {
+		if !matchBound(attr, &c.intervals[i].lower, &hmac_ins, stats) {
+			return false
+		}
+		if matchBound(attr, &c.intervals[i].upper, &hmac_ins, stats) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchBound(*IndexAttributeCipher, *QueryRangeCipher, *hash.Hash, *SearchStats): check whether the attribute matches one bound of the query, and count the evaluations of F
+func matchBound(attr *IndexAttributeCipher, bound *QueryRangeCipher, hmac_ins *hash.Hash, stats *SearchStats) bool {
+	var k1 [sha256.Size]byte      // the result of F, kept on the stack
+	if bound.blockCipher == nil { // the unbounded side
+		return true
+	}
+	for j := range attr.blockCipher { // scan each block
+		candidates := attr.blockCipher[j].subIndex[bound.blockCipher[j].subIndex] // all the blocks which their tags are the same as the query's
+		if len(candidates) == 0 {
+			continue
+		}
+		// perform the hash operation once for the block, and check if any candidate is matched by the query block
+		if *hmac_ins == nil {
+			*hmac_ins = hmac.New(sha256.New, attr.gamma)
+		} else {
+			(*hmac_ins).Reset()
+		}
+		(*hmac_ins).Write(bound.blockCipher[j].cipher)
+		k1Byte := (*hmac_ins).Sum(k1[:0])
+		stats.PRF++
+		for _, targetItem := range candidates {
+			if bytes.Equal(k1Byte, attr.blockCipher[j].ciphers[targetItem]) { // if one item in a block matches, the whole index item matches
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchSlots(int, int, map[KeyID][]*QueryToken, *SearchStats): perform the search procedure on the items in positions [lo, hi) in a single pass and return the positions of the matched items. the upper bound is only checked if the lower bound matches
+func (idx *Index) matchSlots(lo int, hi int, byKey map[KeyID][]*QueryToken, stats *SearchStats) []int {
+	var res []int              // the search result
+	for i := lo; i < hi; i++ { // scan each index item. the deleted items and the items encrypted with a key without token never match
+		tokens, ok := byKey[idx.items[i].keyID]
+		if !ok || idx.deleted(i) {
+			continue
+		}
+		stats.Items++
+		for _, q := range tokens { // the tokens of one key are a disjunction, so the item is added once by the first matched token
+			if matchToken(&idx.items[i], q, stats) {
+				res = append(res, i)
+				break
+			}
+		}
+	}
+	stats.Matches += int64(len(res))
+	return res
+}
+
+// KeyID(): return the key which the item is encrypted with
+func (item *IndexCipher) KeyID() KeyID {
+	return item.keyID
+}
+
+// Revision(): return the revision of the item (0 for an empty item, see Index.Replace)
+func (item *IndexCipher) Revision() Revision {
+	if len(item.attributes) == 0 {
+		return 0
+	}
+	return Revision(binary.BigEndian.Uint64(item.attributes[0].gamma))
+}
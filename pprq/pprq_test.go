@@ -0,0 +1,671 @@
+package pprq
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/JerryXie96/PPRQueryIoT/hilbert"
+)
+
+// the parameters covered by the correctness tests (the small domains make duplicates and the neighbours of the bounds likely)
+var testParams = []struct {
+	name   string
+	params Params
+}{
+	{"1-bit", Params{BlockSize: 1, SubIndexSize: 1, DomainBits: 16}},
+	{"2-bit", Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 16}},
+	{"2-bit/sub-index-1", Params{BlockSize: 2, SubIndexSize: 1, DomainBits: 16}},
+	{"2-bit/big-int", Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 16, Hashing: HashBigInt}},
+	{"3-bit/padded", Params{BlockSize: 3, SubIndexSize: 5, DomainBits: 16}},
+	{"4-bit/8-bit-domain", Params{BlockSize: 4, SubIndexSize: 15, DomainBits: 8}},
+	{"8-bit", Params{BlockSize: 8, SubIndexSize: 16, DomainBits: 16}},
+	{"default", DefaultParams()},
+	{"4-bit/64-bit-domain", Params{BlockSize: 4, SubIndexSize: 7, DomainBits: 64}},
+}
+
+// newTestScheme(testing.TB, Params): create a scheme for the tests
+func newTestScheme(t testing.TB, p Params) *Scheme {
+	t.Helper()
+	s, err := NewScheme(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// randomValue(*rand.Rand, Params): draw a value from the domain uniformly
+func randomValue(r *rand.Rand, p Params) uint64 {
+	if p.MaxValue() == ^uint64(0) {
+		return r.Uint64()
+	}
+	return r.Uint64N(p.MaxValue() + 1)
+}
+
+// plainRange([]uint64, uint64, uint64): the plaintext filter, i.e. the IDs (the positions) of the values in [lo,hi]
+func plainRange(values []uint64, lo uint64, hi uint64) []ItemID {
+	var ids []ItemID
+	for i, v := range values {
+		if v >= lo && v <= hi {
+			ids = append(ids, ItemID(i))
+		}
+	}
+	return ids
+}
+
+// checkQuery(*testing.T, *Scheme, *Index, []uint64, uint64, uint64): check that the search of [lo,hi] (QueryEncRanges) and of (lo,hi) (QueryEnc) returns exactly the values picked by the plaintext filter
+func checkQuery(t *testing.T, s *Scheme, idx *Index, values []uint64, lo uint64, hi uint64) {
+	t.Helper()
+	q, err := s.QueryEncRanges(Range{Lower: lo, Upper: hi})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := idx.Match(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := plainRange(values, lo, hi); !slices.Equal(ids, want) {
+		t.Errorf("[%d,%d]: got %v, want %v", lo, hi, ids, want)
+	}
+
+	if q, err = s.QueryEnc(lo, hi); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err = idx.Match(q); err != nil {
+		t.Fatal(err)
+	}
+	var want []ItemID // the strict bounds
+	if lo < hi && hi-lo >= 2 {
+		want = plainRange(values, lo+1, hi-1)
+	}
+	if !slices.Equal(ids, want) {
+		t.Errorf("(%d,%d): got %v, want %v", lo, hi, ids, want)
+	}
+}
+
+func TestSearchMatchesPlaintext(t *testing.T) {
+	for _, tc := range testParams {
+		t.Run(tc.name, func(t *testing.T) {
+			r := rand.New(rand.NewPCG(1, uint64(len(tc.name))))
+			s := newTestScheme(t, tc.params)
+			values := make([]uint64, 150)
+			for i := range values {
+				values[i] = randomValue(r, tc.params)
+			}
+			idx, err := s.IndexEnc(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 40; i++ {
+				lo, hi := randomValue(r, tc.params), randomValue(r, tc.params)
+				if lo > hi {
+					lo, hi = hi, lo
+				}
+				checkQuery(t, s, idx, values, lo, hi)
+			}
+			// the bounds on the stored values and their neighbours, where an off-by-one would show
+			for i := 0; i < 20; i++ {
+				lo, hi := values[r.IntN(len(values))], values[r.IntN(len(values))]
+				if lo > hi {
+					lo, hi = hi, lo
+				}
+				checkQuery(t, s, idx, values, lo, hi)
+				if lo < hi {
+					checkQuery(t, s, idx, values, lo+1, hi-1)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchEdgeCases(t *testing.T) {
+	for _, tc := range testParams {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestScheme(t, tc.params)
+			max := tc.params.MaxValue()
+			mid := max / 2
+			values := []uint64{0, 1, mid - 1, mid, mid + 1, max - 1, max, 0, max}
+			idx, err := s.IndexEnc(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cases := []struct {
+				name   string
+				lo, hi uint64
+			}{
+				{"whole domain", 0, max},
+				{"zero", 0, 0},
+				{"max", max, max},
+				{"lower==upper", mid, mid},
+				{"lower==upper/no value", mid + 2, mid + 2},
+				{"lower half", 0, mid},
+				{"upper half", mid, max},
+				{"inner", 1, max - 1},
+				{"lower>upper", mid + 1, mid - 1},
+				{"lower>upper/whole domain", max, 0},
+			}
+			for _, c := range cases {
+				t.Run(c.name, func(t *testing.T) {
+					checkQuery(t, s, idx, values, c.lo, c.hi)
+				})
+			}
+		})
+	}
+}
+
+func TestSearchPayloads(t *testing.T) {
+	s := newTestScheme(t, DefaultParams())
+	values := []uint64{5, 10, 15, 20, 25}
+	idx, err := s.IndexEnc(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := s.QueryEncRanges(Range{Lower: 10, Upper: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloads, err := idx.Search(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []uint64
+	for _, sealed := range payloads {
+		pl, err := s.OpenPayload(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, pl.Value)
+	}
+	if want := []uint64{10, 15, 20}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestQueryEncBounds(t *testing.T) {
+	// QueryEnc keeps the strict bounds of the original prototype, and the ranges are inclusive
+	p := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 8}
+	s := newTestScheme(t, p)
+	values := []uint64{0, 9, 10, 11, 19, 20, 21, 255}
+	idx, err := s.IndexEnc(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		enc  func() (*QueryToken, error)
+		want []uint64
+	}{
+		{"(10,20)", func() (*QueryToken, error) { return s.QueryEnc(10, 20) }, []uint64{11, 19}},
+		{"(10,11)", func() (*QueryToken, error) { return s.QueryEnc(10, 11) }, nil},
+		{"(20,10)", func() (*QueryToken, error) { return s.QueryEnc(20, 10) }, nil},
+		{"(0,255)", func() (*QueryToken, error) { return s.QueryEnc(0, 255) }, []uint64{9, 10, 11, 19, 20, 21}},
+		{"[10,20]", func() (*QueryToken, error) { return s.QueryEncRanges(Range{Lower: 10, Upper: 20}) }, []uint64{10, 11, 19, 20}},
+		{"[0,255]", func() (*QueryToken, error) { return s.QueryEncRanges(Range{Lower: 0, Upper: 255}) }, values},
+	}
+	for _, c := range cases {
+		q, err := c.enc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err := idx.Match(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []uint64
+		for _, id := range ids {
+			got = append(got, values[id])
+		}
+		if !slices.Equal(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestOutOfDomain(t *testing.T) {
+	p := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 16}
+	s := newTestScheme(t, p)
+	if _, err := s.IndexItemEnc(p.MaxValue() + 1); !errors.Is(err, ErrValueOutOfDomain) {
+		t.Errorf("IndexItemEnc: got %v, want ErrValueOutOfDomain", err)
+	}
+	if _, err := s.QueryEnc(0, p.MaxValue()+1); !errors.Is(err, ErrValueOutOfDomain) {
+		t.Errorf("QueryEnc: got %v, want ErrValueOutOfDomain", err)
+	}
+	if _, err := s.IndexEnc([]uint64{1, p.MaxValue() + 1}); !errors.Is(err, ErrValueOutOfDomain) {
+		t.Errorf("IndexEnc: got %v, want ErrValueOutOfDomain", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	s := newTestScheme(t, DefaultParams())
+	values := []uint64{5, 10, 15, 20, 25}
+	idx, err := s.IndexEnc(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := s.PlainValues(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain) != len(values) {
+		t.Fatalf("got %d plaintext values, want %d", len(plain), len(values))
+	}
+
+	r, err := s.Verify(idx, plain, 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() || r.Returned != 3 || r.Expected != 3 || r.Precision() != 1 || r.Recall() != 1 {
+		t.Errorf("verify of the genuine values: %v", r)
+	}
+
+	// the wrong plaintext of two items shows up as one false positive and one false negative
+	plain[1], plain[4] = 30, 12
+	r, err = s.Verify(idx, plain, 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Mismatch{{ID: 1, Value: 30}}; !slices.Equal(r.FalsePositives, want) {
+		t.Errorf("false positives: got %v, want %v", r.FalsePositives, want)
+	}
+	if want := []Mismatch{{ID: 4, Value: 12}}; !slices.Equal(r.FalseNegatives, want) {
+		t.Errorf("false negatives: got %v, want %v", r.FalseNegatives, want)
+	}
+	if r.Precision() != 2.0/3 || r.Recall() != 2.0/3 {
+		t.Errorf("got precision %v and recall %v, want 2/3", r.Precision(), r.Recall())
+	}
+
+	delete(plain, 2)
+	if _, err := s.Verify(idx, plain, 10, 20); !errors.Is(err, ErrNoSuchItem) {
+		t.Errorf("missing value: got %v, want ErrNoSuchItem", err)
+	}
+}
+
+func TestIndexPoints(t *testing.T) {
+	p := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 16} // a 256*256 grid
+	s := newTestScheme(t, p)
+	points := []hilbert.Point{{X: 0, Y: 0}, {X: 25, Y: 56}, {X: 67, Y: 124}, {X: 255, Y: 0}}
+	idx, err := s.IndexEncPoints(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := s.QueryEncRanges(Range{Lower: 0, Upper: p.MaxValue()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, payloads, err := idx.SearchContext(context.Background(), SearchOptions{}, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, sealed := range payloads {
+		pl, err := s.OpenPayload(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pt, err := p.DecodePoint(pl.Value); err != nil || pt != points[i] {
+			t.Errorf("item %d: got %v, %v, want %v", i, pt, err, points[i])
+		}
+	}
+
+	if _, err := s.IndexPointEnc(hilbert.Point{X: 256}); !errors.Is(err, ErrValueOutOfDomain) {
+		t.Errorf("point out of the grid: got %v, want ErrValueOutOfDomain", err)
+	}
+	odd := newTestScheme(t, Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 15})
+	if _, err := odd.IndexPointEnc(hilbert.Point{}); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("odd domain bits: got %v, want ErrInvalidParams", err)
+	}
+}
+
+func TestQueryEncBox(t *testing.T) {
+	p := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 12} // a 64*64 grid, or a 16*16*16 grid (e.g. (x, y, time))
+	s := newTestScheme(t, p)
+	c, err := p.Curve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := p.HilbertND(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := p.ZOrder(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rect := hilbert.Rect{Min: hilbert.Point{X: 10, Y: 20}, Max: hilbert.Point{X: 40, Y: 35}}
+	box := hilbert.Box{Min: []uint64{2, 5, 0}, Max: []uint64{11, 9, 6}}
+	cases := []struct {
+		name  string
+		m     hilbert.Mapping
+		box   hilbert.Box
+		query func(maxIntervals int) (*QueryToken, error) // the query of the box (nil: QueryEncBox)
+	}{
+		{"rect", c, rect.Box(), func(maxIntervals int) (*QueryToken, error) { return s.QueryEncRect(rect, maxIntervals) }},
+		{"hilbert", h, box, nil},
+		{"z-order", z, box, nil},
+	}
+	r := rand.New(rand.NewPCG(9, 10))
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			points := make([][]uint64, 300)
+			var want []ItemID
+			for i := range points {
+				points[i] = make([]uint64, tc.m.Dims())
+				for j := range points[i] {
+					points[i][j] = r.Uint64N(tc.m.Side() + 1)
+				}
+				if tc.box.Contains(points[i]) {
+					want = append(want, ItemID(i))
+				}
+			}
+			idx, err := s.IndexEncCoords(tc.m, points)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, maxIntervals := range []int{0, 8, 1} {
+				var q *QueryToken
+				if tc.query != nil {
+					q, err = tc.query(maxIntervals)
+				} else {
+					q, err = s.QueryEncBox(tc.m, tc.box, maxIntervals)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if maxIntervals > 0 && q.Intervals() > maxIntervals {
+					t.Errorf("cap %d: %d intervals", maxIntervals, q.Intervals())
+				}
+				ids, err := idx.Match(q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// the exact decomposition returns the points in the box, and a capped one returns a superset of them
+				if maxIntervals == 0 && !slices.Equal(ids, want) {
+					t.Errorf("no cap: got %v, want %v", ids, want)
+				}
+				for _, id := range want {
+					if _, found := slices.BinarySearch(ids, id); !found {
+						t.Errorf("cap %d: item %d missed", maxIntervals, id)
+					}
+				}
+			}
+			if coords, err := p.DecodeCoords(tc.m, mustEncodeCoords(t, p, tc.m, points[0])); err != nil || !slices.Equal(coords, points[0]) {
+				t.Errorf("DecodeCoords: got %v, %v, want %v", coords, err, points[0])
+			}
+		})
+	}
+
+	// a curve beyond the domain is rejected
+	big, err := hilbert.NewHilbertND(3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.QueryEncBox(big, box, 0); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("15-bit curve in 12-bit domain: %v", err)
+	}
+	if _, err := s.IndexCoordsEnc(h, []uint64{16, 0, 0}); !errors.Is(err, ErrValueOutOfDomain) {
+		t.Errorf("coordinate 16: %v", err)
+	}
+}
+
+func TestQueryEncBoxCapped(t *testing.T) {
+	p := Params{BlockSize: 2, SubIndexSize: 3, DomainBits: 64} // a 2^32*2^32 grid, or a 2^16*2^16*2^16*2^16 grid
+	s := newTestScheme(t, p)
+	h, err := p.HilbertND(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := p.ZOrder(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rect := hilbert.Rect{Min: hilbert.Point{X: 12345, Y: 678}, Max: hilbert.Point{X: 1<<32 - 9876, Y: 1<<31 + 54321}}
+	box := hilbert.Box{Min: []uint64{3, 1000, 70, 5}, Max: []uint64{60000, 65000, 40000, 1 << 15}}
+
+	// the capped queries of large boxes are generated in a bounded time (their exact decompositions have billions of intervals)
+	for _, tc := range []struct {
+		name  string
+		query func() (*QueryToken, error)
+	}{
+		{"rect", func() (*QueryToken, error) { return s.QueryEncRect(rect, 8) }},
+		{"hilbert", func() (*QueryToken, error) { return s.QueryEncBox(h, box, 8) }},
+		{"z-order", func() (*QueryToken, error) { return s.QueryEncBox(z, box, 8) }},
+	} {
+		start := time.Now()
+		q, err := tc.query()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: capped query took %v", tc.name, elapsed)
+		}
+		if q.Intervals() == 0 || q.Intervals() > 8 {
+			t.Errorf("%s: %d intervals, want 1..8", tc.name, q.Intervals())
+		}
+	}
+}
+
+// mustEncodeCoords(testing.TB, Params, hilbert.Mapping, []uint64): EncodeCoords which fails the test on errors
+func mustEncodeCoords(t testing.TB, p Params, m hilbert.Mapping, coords []uint64) uint64 {
+	t.Helper()
+	d, err := p.EncodeCoords(m, coords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestQueryEncRanges(t *testing.T) {
+	for _, tc := range testParams[:4] {
+		t.Run(tc.name, func(t *testing.T) {
+			r := rand.New(rand.NewPCG(7, uint64(len(tc.name))))
+			s := newTestScheme(t, tc.params)
+			values := make([]uint64, 150)
+			for i := range values {
+				values[i] = randomValue(r, tc.params)
+			}
+			idx, err := s.IndexEnc(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 20; i++ {
+				// up to four ranges which may overlap, touch or be empty
+				ranges := make([]Range, r.IntN(5))
+				for j := range ranges {
+					ranges[j] = Range{Lower: randomValue(r, tc.params), Upper: randomValue(r, tc.params)}
+					if r.IntN(4) > 0 && ranges[j].Lower > ranges[j].Upper {
+						ranges[j].Lower, ranges[j].Upper = ranges[j].Upper, ranges[j].Lower
+					}
+				}
+				var want []ItemID
+				for id, v := range values {
+					if slices.ContainsFunc(ranges, func(rg Range) bool { return v >= rg.Lower && v <= rg.Upper }) {
+						want = append(want, ItemID(id))
+					}
+				}
+
+				q, err := s.QueryEncRanges(ranges...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := q.MarshalBinary()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var decoded QueryToken
+				if err := decoded.UnmarshalBinary(b); err != nil {
+					t.Fatal(err)
+				}
+				ids, err := idx.Match(&decoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(ids, want) {
+					t.Errorf("%v: got %v, want %v", ranges, ids, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeRanges(t *testing.T) {
+	max := ^uint64(0)
+	tests := []struct {
+		name   string
+		ranges []Range
+		want   []Range
+	}{
+		{"none", nil, nil},
+		{"empty", []Range{{Lower: 5, Upper: 4}}, nil},
+		{"sorted", []Range{{Lower: 50, Upper: 60}, {Lower: 0, Upper: 4}}, []Range{{Lower: 0, Upper: 4}, {Lower: 50, Upper: 60}}},
+		{"overlapping", []Range{{Lower: 0, Upper: 10}, {Lower: 5, Upper: 20}, {Lower: 6, Upper: 7}}, []Range{{Lower: 0, Upper: 20}}},
+		{"adjacent", []Range{{Lower: 0, Upper: 4}, {Lower: 5, Upper: 9}}, []Range{{Lower: 0, Upper: 9}}},
+		{"end of domain", []Range{{Lower: 10, Upper: max}, {Lower: max, Upper: max}}, []Range{{Lower: 10, Upper: max}}},
+	}
+	for _, tt := range tests {
+		if got := normalizeRanges(tt.ranges); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRecords(t *testing.T) {
+	for _, tc := range testParams[:4] {
+		t.Run(tc.name, func(t *testing.T) {
+			r := rand.New(rand.NewPCG(11, uint64(len(tc.name))))
+			s := newTestScheme(t, tc.params)
+			// the records of three attributes, and a few single values which never match a condition on attribute 1 or 2
+			records := make([][]uint64, 120)
+			idx, err := NewIndex(tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range records {
+				n := 3
+				if i%10 == 0 {
+					n = 1
+				}
+				records[i] = make([]uint64, n)
+				for a := range records[i] {
+					records[i][a] = randomValue(r, tc.params)
+				}
+				item, err := s.IndexRecordEnc(records[i]...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := idx.Append(item); err != nil {
+					t.Fatal(err)
+				}
+			}
+			b, err := idx.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded Index
+			if err := decoded.UnmarshalBinary(b); err != nil {
+				t.Fatal(err)
+			}
+
+			randomRange := func() Range {
+				lo, hi := randomValue(r, tc.params), randomValue(r, tc.params)
+				return Range{Lower: min(lo, hi), Upper: max(lo, hi)}
+			}
+			for i := 0; i < 20; i++ {
+				conditions := make([]Condition, 1+r.IntN(3))
+				for j := range conditions {
+					conditions[j] = Condition{Attribute: r.IntN(3), Ranges: []Range{randomRange()}}
+				}
+				var want []ItemID
+				for id, rec := range records {
+					if !slices.ContainsFunc(conditions, func(c Condition) bool {
+						return c.Attribute >= len(rec) || rec[c.Attribute] < c.Ranges[0].Lower || rec[c.Attribute] > c.Ranges[0].Upper
+					}) {
+						want = append(want, ItemID(id))
+					}
+				}
+
+				q, err := s.QueryEncConjunction(conditions...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tb, err := q.MarshalBinary()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var token QueryToken
+				if err := token.UnmarshalBinary(tb); err != nil {
+					t.Fatal(err)
+				}
+				if token.Conditions() != len(conditions) {
+					t.Fatalf("%d conditions after the round trip (want %d)", token.Conditions(), len(conditions))
+				}
+				ids, err := decoded.Match(&token)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(ids, want) {
+					t.Errorf("%v: got %v, want %v", conditions, ids, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordShortCircuit(t *testing.T) {
+	p := DefaultParams()
+	s := newTestScheme(t, p)
+	item, err := s.IndexRecordEnc(100, 2000, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := NewIndex(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Append(item); err != nil {
+		t.Fatal(err)
+	}
+	pl, err := s.OpenPayload(item.payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.Value != 100 || !slices.Equal(pl.Attributes, []uint64{2000, 30}) {
+		t.Fatalf("payload %+v", pl)
+	}
+
+	prf := func(conditions ...Condition) (int, int64) {
+		q, err := s.QueryEncConjunction(conditions...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stats SearchStats
+		ids, err := idx.MatchContext(context.Background(), SearchOptions{Stats: &stats}, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(ids), stats.PRF
+	}
+	fail := Condition{Attribute: 2, Ranges: []Range{{Lower: 40, Upper: 50}}}
+	pass := Condition{Attribute: 1, Ranges: []Range{{Lower: 1000, Upper: 3000}}}
+	n, both := prf(pass, fail)
+	if n != 0 {
+		t.Fatalf("%d matches of a failed conjunction", n)
+	}
+	n, first := prf(fail, pass)
+	if n != 0 || first >= both {
+		t.Errorf("%d matches and %d evaluations of F with the failed condition first (%d with it last)", n, first, both)
+	}
+	if n, _ := prf(pass, Condition{Attribute: 0, Ranges: []Range{{Lower: 0, Upper: 100}}}); n != 1 {
+		t.Errorf("%d matches of a satisfied conjunction", n)
+	}
+	if n, _ := prf(Condition{Attribute: 3, Ranges: []Range{{Lower: 0, Upper: 100}}}); n != 0 {
+		t.Errorf("%d matches of a condition on a missing attribute", n)
+	}
+	if _, err := s.QueryEncConjunction(Condition{Attribute: maxAttributes}); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("attribute %d: %v", maxAttributes, err)
+	}
+}
@@ -0,0 +1,79 @@
+package pprq
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"strconv"
+)
+
+/*
+	A record is an index item with several attributes (e.g. the temperature, the humidity and the battery level reported together). Attribute 0 is pl.Value and the others are pl.Attributes (see Payload).
+
+	Every attribute is encrypted like a single value with its own nonce and its own HMAC key, so the attributes of one record (or of different records) cannot be compared with each other. The key of attribute 0 is the key material itself, so a single value is a record of one attribute, and the key of attribute a > 0 is derived from the key material with HKDF-SHA256.
+	A query is a conjunction of conditions, each a union of ranges on one attribute. The search evaluates the conditions in order and stops at the first one which fails, so the most selective condition should come first.
+*/
+
+const (
+	maxAttributes int    = 16                // the max number of attributes in one record
+	attributeInfo string = "pprq attribute " // the HKDF info of the key of an attribute (followed by the attribute in decimal)
+)
+
+// Condition: the condition on one attribute of the records, i.e. the union of the ranges
+type Condition struct {
+	Attribute int     // the attribute (0: Payload.Value, a > 0: Payload.Attributes[a-1])
+	Ranges    []Range // the ranges of the attribute (see QueryEncRanges)
+}
+
+// attributeKeys(*Key): derive the HMAC keys of all the attributes from the key
+func attributeKeys(k *Key) ([][]byte, error) {
+	keys := make([][]byte, maxAttributes)
+	keys[0] = k.material // the key of the single values
+	for a := 1; a < maxAttributes; a++ {
+		key, err := hkdf.Key(sha256.New, k.material, nil, attributeInfo+strconv.Itoa(a), sha256.Size)
+		if err != nil {
+			return nil, wrapError(ErrInvalidKey, "%v", err)
+		}
+		keys[a] = key
+	}
+	return keys, nil
+}
+
+// IndexRecordEnc(...uint64): encrypt the values as the attributes of one record in index (values[0] is attribute 0)
+func (s *Scheme) IndexRecordEnc(values ...uint64) (IndexCipher, error) {
+	if len(values) == 0 {
+		return IndexCipher{}, wrapError(ErrInvalidParams, "record without attribute")
+	}
+	return s.IndexItemEncPayload(Payload{Value: values[0], Attributes: values[1:]})
+}
+
+// QueryEncConjunction(...Condition): generate the ciphertext of the query which matches the records satisfying all the conditions (e.g. temperature in [a,b] AND humidity in [c,d]). the records without the attribute of a condition never match
+func (s *Scheme) QueryEncConjunction(conditions ...Condition) (*QueryToken, error) {
+	if len(conditions) == 0 {
+		return nil, wrapError(ErrInvalidParams, "query without condition")
+	}
+	for _, c := range conditions {
+		if c.Attribute < 0 || c.Attribute >= maxAttributes {
+			return nil, wrapError(ErrInvalidParams, "attribute %d not in [0,%d)", c.Attribute, maxAttributes)
+		}
+		for _, r := range c.Ranges {
+			if err := s.params.checkValue(r.Lower); err != nil {
+				return nil, err
+			}
+			if err := s.params.checkValue(r.Upper); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	e := s.getEncryptor()
+	defer s.putEncryptor(e)
+	q := &QueryToken{params: s.params, keyID: s.key.id, conditions: make([]QueryConditionCipher, len(conditions))}
+	for i, c := range conditions {
+		ranges := normalizeRanges(c.Ranges)
+		q.conditions[i] = QueryConditionCipher{attribute: c.Attribute, intervals: make([]QueryIntervalCipher, len(ranges))}
+		for j, r := range ranges {
+			q.conditions[i].intervals[j] = s.queryIntervalEnc(e, c.Attribute, r)
+		}
+	}
+	return q, nil
+}
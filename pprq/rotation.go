@@ -0,0 +1,75 @@
+package pprq
+
+import "slices"
+
+// Rotation: re-encrypt an index from one key to another in batches. the items of both keys stay searchable during the rotation, with one token per key (see Index.Match). the devices may keep updating and appending items with the retiring key, so the rotation takes passes until Remaining is 0
+type Rotation struct {
+	from *Scheme // the scheme with the retiring key
+	to   *Scheme // the scheme with the new key
+	next ItemID  // the ID of the next item to be checked
+}
+
+// NewRotation(*Scheme, *Scheme): create a rotation from the key of the scheme from to the key of the scheme to. both schemes must share the parameters
+func NewRotation(from *Scheme, to *Scheme) (*Rotation, error) {
+	if from.params != to.params {
+		return nil, mismatch(to.params, from.params)
+	}
+	if from.key.id == to.key.id {
+		return nil, wrapError(ErrInvalidKey, "rotation to the same key %s", from.key.id)
+	}
+	return &Rotation{from: from, to: to}, nil
+}
+
+// Next(*Index, int): re-encrypt the next batch of at most n items of the retiring key in one pass over the index. it returns the IDs and the revisions of the items and the items which replace them (see Index.Replace), or no items at the end of the pass. the items updated or appended behind the pass, and the batches rejected with ErrConflict, are left to the next pass (see Restart and Remaining)
+func (r *Rotation) Next(idx *Index, n int) ([]ItemID, []Revision, []IndexCipher, error) {
+	var (
+		ids       []ItemID
+		revisions []Revision
+		batch     []IndexCipher
+	)
+	if idx.params != r.from.params {
+		return nil, nil, nil, mismatch(idx.params, r.from.params)
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	start, _ := slices.BinarySearch(idx.ids, r.next) // the IDs are in ascending order
+	for i := start; i < len(idx.items); i++ {
+		if len(batch) >= max(n, 1) {
+			break
+		}
+		if idx.deleted(i) || idx.items[i].keyID != r.from.key.id { // skip the items which need no re-encryption
+			continue
+		}
+		pl, err := r.from.OpenPayload(idx.items[i].payload) // the value is recovered from the payload, and the metadata is kept
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		item, err := r.to.IndexItemEncPayload(pl)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		ids = append(ids, idx.ids[i])
+		revisions = append(revisions, idx.items[i].Revision())
+		batch = append(batch, item)
+		r.next = idx.ids[i] + 1
+	}
+	return ids, revisions, batch, nil
+}
+
+// Restart(): start a new pass over the index from its first item
+func (r *Rotation) Restart() {
+	r.next = 0
+}
+
+// Remaining(*Index): return the number of items in idx which are still encrypted with the retiring key
+func (r *Rotation) Remaining(idx *Index) int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	count := 0
+	for i := range idx.items {
+		if !idx.deleted(i) && idx.items[i].keyID == r.from.key.id {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,166 @@
+package pprq
+
+import (
+	"errors"
+	"maps"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// rotatePass(*testing.T, *Rotation, *Index, int): run one pass of the rotation over idx and return the number of batches rejected with ErrConflict
+func rotatePass(t *testing.T, r *Rotation, idx *Index, n int) int {
+	t.Helper()
+	conflicts := 0
+	r.Restart()
+	for {
+		ids, revisions, items, err := r.Next(idx, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) == 0 {
+			return conflicts
+		}
+		runtime.Gosched() // let the concurrent updates run between the re-encryption and the replacement
+		if err := idx.Replace(ids, revisions, items); errors.Is(err, ErrConflict) {
+			conflicts++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRotationConflict(t *testing.T) {
+	from := newTestScheme(t, DefaultParams())
+	to := newTestScheme(t, DefaultParams())
+	idx, err := from.IndexEnc([]uint64{10, 20, 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRotation(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the item is updated between the re-encryption and the replacement, so the stale batch is rejected as a whole
+	ids, revisions, items, err := r.Next(idx, 10)
+	if err != nil || len(items) != 3 {
+		t.Fatalf("Next: %d items, %v", len(items), err)
+	}
+	updated, err := from.IndexItemEnc(999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Update(0, updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Replace(ids, revisions, items); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Replace of a stale batch: got %v, want ErrConflict", err)
+	}
+	if r.Remaining(idx) != 3 {
+		t.Errorf("%d items remaining after the conflict, want 3", r.Remaining(idx))
+	}
+	// the counts which do not match are a bad request rather than a missing item
+	if err := idx.Replace(ids, revisions[:2], items); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Replace with missing revisions: got %v, want ErrInvalidParams", err)
+	}
+	if err := idx.Replace(ids, revisions, items[:2]); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Replace with missing items: got %v, want ErrInvalidParams", err)
+	}
+
+	// the pass is over, and the item appended with the retiring key is rotated by the next one
+	if _, _, items, err := r.Next(idx, 10); err != nil || len(items) != 0 {
+		t.Fatalf("Next at the end of the pass: %d items, %v", len(items), err)
+	}
+	appended, err := from.IndexItemEnc(40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Append(appended); err != nil {
+		t.Fatal(err)
+	}
+	if conflicts := rotatePass(t, r, idx, 10); conflicts != 0 {
+		t.Errorf("%d conflicts without updates", conflicts)
+	}
+	if r.Remaining(idx) != 0 {
+		t.Errorf("%d items remaining after the rotation", r.Remaining(idx))
+	}
+	values, err := to.PlainValues(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[ItemID]uint64{0: 999, 1: 20, 2: 30, 3: 40}; !maps.Equal(values, want) {
+		t.Errorf("values after the rotation: got %v, want %v", values, want)
+	}
+}
+
+func TestRotationConcurrentUpdate(t *testing.T) {
+	from := newTestScheme(t, DefaultParams())
+	to := newTestScheme(t, DefaultParams())
+	initial := make([]uint64, 32)
+	want := make(map[ItemID]uint64)
+	for i := range initial {
+		initial[i] = uint64(i)
+		want[ItemID(i)] = uint64(i)
+	}
+	idx, err := from.IndexEnc(initial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRotation(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a device updates every item once with the retiring key during the first passes, so a replacement by a stale batch would undo its update for good
+	var (
+		wg        sync.WaitGroup
+		done      = make(chan struct{})
+		conflicts int
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := range initial {
+			id, v := ItemID(i), uint64(1000+i)
+			item, err := from.IndexItemEnc(v)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := idx.Update(id, item); err != nil {
+				t.Error(err)
+				return
+			}
+			want[id] = v
+			runtime.Gosched()
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		conflicts += rotatePass(t, r, idx, 4)
+	}
+	wg.Wait()
+	if conflicts == 0 {
+		t.Log("no batch conflicted with the updates")
+	}
+
+	for pass := 0; r.Remaining(idx) > 0; pass++ {
+		if pass == 3 {
+			t.Fatalf("%d items remaining after the passes without updates", r.Remaining(idx))
+		}
+		rotatePass(t, r, idx, 4)
+	}
+	values, err := to.PlainValues(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(values, want) {
+		t.Errorf("values after the rotation: got %v, want %v (an update was undone)", values, want)
+	}
+}
@@ -0,0 +1,145 @@
+package pprq
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// SearchOptions: the options of one search
+type SearchOptions struct {
+	Workers int          // the number of goroutines which scan the index in parallel (0: runtime.GOMAXPROCS)
+	Stats   *SearchStats // if not nil, it is set to the counters of the search
+}
+
+// SearchStats: the counters of one search for performance tuning
+type SearchStats struct {
+	Items   int64 `json:"items"`   // the number of items scanned (the deleted items and the items of the keys without token are skipped)
+	PRF     int64 `json:"prf"`     // the number of evaluations of F
+	Matches int64 `json:"matches"` // the number of matched items
+}
+
+// add(*SearchStats): add the counters of other to s
+func (s *SearchStats) add(other *SearchStats) {
+	s.Items += other.Items
+	s.PRF += other.PRF
+	s.Matches += other.Matches
+}
+
+const searchChunk int = 256 // the number of items scanned by one worker at a time
+
+// match(context.Context, SearchOptions, []*QueryToken): scan the index with a pool of workers and return the positions of the matched items in ascending order. the caller holds the read lock
+func (idx *Index) match(ctx context.Context, opts SearchOptions, tokens []*QueryToken) ([]int, error) {
+	byKey, err := tokensByKey(idx.params, tokens)
+	if err != nil {
+		return nil, err
+	}
+	chunks := (len(idx.items) + searchChunk - 1) / searchChunk
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, chunks)
+
+	var (
+		wg      sync.WaitGroup
+		next    = make(chan int)              // the chunks to be scanned
+		results = make([][]int, chunks)       // the matched positions of each chunk
+		stats   = make([]SearchStats, chunks) // the counters of each chunk
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range next {
+				results[c] = idx.matchSlots(c*searchChunk, min((c+1)*searchChunk, len(idx.items)), byKey, &stats[c])
+			}
+		}()
+	}
+	for c := 0; c < chunks && ctx.Err() == nil; c++ {
+		select {
+		case next <- c:
+		case <-ctx.Done():
+		}
+	}
+	close(next)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		res   []int
+		total SearchStats
+	)
+	for c := range results {
+		res = append(res, results[c]...)
+		total.add(&stats[c])
+	}
+	if opts.Stats != nil {
+		*opts.Stats = total
+	}
+	return res, nil
+}
+
+// tokensByKey(Params, []*QueryToken): check the tokens and group them by their keys. the tokens of one key are a disjunction, e.g. the intervals of a rectangle query
+func tokensByKey(p Params, tokens []*QueryToken) (map[KeyID][]*QueryToken, error) {
+	if len(tokens) == 0 {
+		return nil, malformedToken("no query token")
+	}
+	byKey := make(map[KeyID][]*QueryToken)
+	for _, q := range tokens {
+		if q == nil {
+			return nil, malformedToken("nil query token")
+		}
+		if err := q.Check(p); err != nil {
+			return nil, err
+		}
+		byKey[q.keyID] = append(byKey[q.keyID], q)
+	}
+	return byKey, nil
+}
+
+// MatchContext(context.Context, SearchOptions, ...*QueryToken): perform the search procedure and return the IDs of the matched index items. each token only matches the items encrypted with its key, so an index in key rotation is searched with one token per key. an item matched by several tokens of its key (e.g. the intervals of QueryEncRect) is returned once. a query generated with other parameters is rejected, and the search stops with the error of ctx once it is cancelled or its deadline is exceeded
+func (idx *Index) MatchContext(ctx context.Context, opts SearchOptions, tokens ...*QueryToken) ([]ItemID, error) {
+	ids, _, err := idx.search(ctx, opts, tokens, false)
+	return ids, err
+}
+
+// SearchContext(context.Context, SearchOptions, ...*QueryToken): perform the search procedure like MatchContext, and also return the sealed payloads of the matched index items (see Scheme.OpenPayload)
+func (idx *Index) SearchContext(ctx context.Context, opts SearchOptions, tokens ...*QueryToken) ([]ItemID, [][]byte, error) {
+	return idx.search(ctx, opts, tokens, true)
+}
+
+// search(context.Context, SearchOptions, []*QueryToken, bool): collect the IDs (and the payloads if withPayloads is set) of the matched items under the read lock, so the result is consistent with concurrent updates
+func (idx *Index) search(ctx context.Context, opts SearchOptions, tokens []*QueryToken, withPayloads bool) ([]ItemID, [][]byte, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	slots, err := idx.match(ctx, opts, tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := make([]ItemID, len(slots))
+	for i, slot := range slots {
+		ids[i] = idx.ids[slot]
+	}
+	if !withPayloads {
+		return ids, nil, nil
+	}
+	payloads := make([][]byte, len(slots))
+	for i, slot := range slots {
+		payloads[i] = idx.items[slot].payload
+	}
+	return ids, payloads, nil
+}
+
+// Match(...*QueryToken): perform the search procedure with the default options (see MatchContext)
+func (idx *Index) Match(tokens ...*QueryToken) ([]ItemID, error) {
+	return idx.MatchContext(context.Background(), SearchOptions{}, tokens...)
+}
+
+// Search(...*QueryToken): perform the search procedure with the default options and return the sealed payloads of the matched index items (see SearchContext)
+func (idx *Index) Search(tokens ...*QueryToken) ([][]byte, error) {
+	_, payloads, err := idx.SearchContext(context.Background(), SearchOptions{}, tokens...)
+	return payloads, err
+}
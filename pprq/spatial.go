@@ -0,0 +1,165 @@
+package pprq
+
+import (
+	"github.com/JerryXie96/PPRQueryIoT/hilbert"
+)
+
+// Curve(): return the Hilbert curve which maps the 2-D points into the domain. each coordinate has DomainBits/2 bits, so DomainBits must be even
+func (p Params) Curve() (*hilbert.Curve, error) {
+	if p.DomainBits%2 != 0 {
+		return nil, wrapError(ErrInvalidParams, "odd domain bits %d for 2-D points", p.DomainBits)
+	}
+	return hilbert.New(p.DomainBits / 2)
+}
+
+// EncodePoint(hilbert.Point): encode a 2-D point into the domain as its distance along the Hilbert curve (the encoding of the parameters only applies to the 1-D values)
+func (p Params) EncodePoint(pt hilbert.Point) (uint64, error) {
+	c, err := p.Curve()
+	if err != nil {
+		return 0, err
+	}
+	return p.EncodeCoords(c, []uint64{pt.X, pt.Y})
+}
+
+// DecodePoint(uint64): the inverse of EncodePoint (e.g. for the value of an opened payload)
+func (p Params) DecodePoint(d uint64) (hilbert.Point, error) {
+	c, err := p.Curve()
+	if err != nil {
+		return hilbert.Point{}, err
+	}
+	coords, err := p.DecodeCoords(c, d)
+	if err != nil {
+		return hilbert.Point{}, err
+	}
+	return hilbert.Point{X: coords[0], Y: coords[1]}, nil
+}
+
+// IndexPointEnc(hilbert.Point): encrypt a 2-D point as one item in index
+func (s *Scheme) IndexPointEnc(pt hilbert.Point) (IndexCipher, error) {
+	d, err := s.params.EncodePoint(pt)
+	if err != nil {
+		return IndexCipher{}, err
+	}
+	return s.IndexItemEnc(d)
+}
+
+// IndexEncPoints([]hilbert.Point): encrypt all the 2-D points as the index items in parallel (their IDs are 0, 1, ... in the order of points)
+func (s *Scheme) IndexEncPoints(points []hilbert.Point) (*Index, error) {
+	c, err := s.params.Curve()
+	if err != nil {
+		return nil, err
+	}
+	coords := make([][]uint64, len(points))
+	for i, pt := range points {
+		coords[i] = []uint64{pt.X, pt.Y}
+	}
+	return s.IndexEncCoords(c, coords)
+}
+
+// QueryEncRect(hilbert.Rect, int): generate the token of a rectangle query, i.e. the union of the Hilbert intervals covering the rectangle (see hilbert.Curve.Intervals). maxIntervals caps the number of intervals (0: no cap); a smaller cap makes the token smaller and the search faster (and bounds the cost of the decomposition on a large grid), but returns the points in the gaps between the merged intervals (which the owner filters out after opening the payloads)
+func (s *Scheme) QueryEncRect(r hilbert.Rect, maxIntervals int) (*QueryToken, error) {
+	c, err := s.params.Curve()
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryEncBox(c, r.Box(), maxIntervals)
+}
+
+// HilbertND(int): return the Hilbert curve which maps the points of dims dimensions (e.g. (x, y, time) or (x, y, floor)) into the domain. each coordinate has DomainBits/dims bits, and the distances fill the domain if dims divides DomainBits
+func (p Params) HilbertND(dims int) (*hilbert.HilbertND, error) {
+	if dims < 1 || p.DomainBits < dims {
+		return nil, wrapError(ErrInvalidParams, "%d dimensions for %d domain bits", dims, p.DomainBits)
+	}
+	h, err := hilbert.NewHilbertND(dims, p.DomainBits/dims)
+	if err != nil {
+		return nil, wrapError(ErrInvalidParams, "%v", err)
+	}
+	return h, nil
+}
+
+// ZOrder(int): return the Z-order curve which maps the points of dims dimensions into the domain (see HilbertND). it is cheaper to encode, but a box query needs more intervals
+func (p Params) ZOrder(dims int) (*hilbert.ZOrder, error) {
+	if dims < 1 || p.DomainBits < dims {
+		return nil, wrapError(ErrInvalidParams, "%d dimensions for %d domain bits", dims, p.DomainBits)
+	}
+	z, err := hilbert.NewZOrder(dims, p.DomainBits/dims)
+	if err != nil {
+		return nil, wrapError(ErrInvalidParams, "%v", err)
+	}
+	return z, nil
+}
+
+// checkMapping(hilbert.Mapping): check whether the distances of the mapping fit in the domain
+func (p Params) checkMapping(m hilbert.Mapping) error {
+	if m.MaxDistance() > p.MaxValue() {
+		return wrapError(ErrInvalidParams, "%d-D curve of order %d exceeds %d domain bits", m.Dims(), m.Order(), p.DomainBits)
+	}
+	return nil
+}
+
+// EncodeCoords(hilbert.Mapping, []uint64): encode an N-D point into the domain as its distance along the curve of m (e.g. from HilbertND or ZOrder)
+func (p Params) EncodeCoords(m hilbert.Mapping, coords []uint64) (uint64, error) {
+	if err := p.checkMapping(m); err != nil {
+		return 0, err
+	}
+	d, err := m.Encode(coords)
+	if err != nil {
+		return 0, wrapError(ErrValueOutOfDomain, "%v", err)
+	}
+	return d, nil
+}
+
+// DecodeCoords(hilbert.Mapping, uint64): the inverse of EncodeCoords (e.g. for the value of an opened payload)
+func (p Params) DecodeCoords(m hilbert.Mapping, d uint64) ([]uint64, error) {
+	if err := p.checkMapping(m); err != nil {
+		return nil, err
+	}
+	coords, err := m.Decode(d)
+	if err != nil {
+		return nil, wrapError(ErrValueOutOfDomain, "%v", err)
+	}
+	return coords, nil
+}
+
+// IndexCoordsEnc(hilbert.Mapping, []uint64): encrypt an N-D point as one item in index
+func (s *Scheme) IndexCoordsEnc(m hilbert.Mapping, coords []uint64) (IndexCipher, error) {
+	d, err := s.params.EncodeCoords(m, coords)
+	if err != nil {
+		return IndexCipher{}, err
+	}
+	return s.IndexItemEnc(d)
+}
+
+// IndexEncCoords(hilbert.Mapping, [][]uint64): encrypt all the N-D points as the index items in parallel (their IDs are 0, 1, ... in the order of points)
+func (s *Scheme) IndexEncCoords(m hilbert.Mapping, points [][]uint64) (*Index, error) {
+	values := make([]uint64, len(points))
+	for i, coords := range points {
+		d, err := s.params.EncodeCoords(m, coords)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = d
+	}
+	return s.IndexEnc(values)
+}
+
+// QueryEncBox(hilbert.Mapping, hilbert.Box, int): generate the token of a box query on the N-D points, i.e. the union of the intervals of m covering the box (see QueryEncRect for maxIntervals)
+func (s *Scheme) QueryEncBox(m hilbert.Mapping, b hilbert.Box, maxIntervals int) (*QueryToken, error) {
+	if err := s.params.checkMapping(m); err != nil {
+		return nil, err
+	}
+	intervals, err := m.Intervals(b, maxIntervals)
+	if err != nil {
+		return nil, wrapError(ErrValueOutOfDomain, "%v", err)
+	}
+	return s.queryEncIntervals(intervals)
+}
+
+// queryEncIntervals([]hilbert.Interval): generate the token of the union of the intervals along a curve
+func (s *Scheme) queryEncIntervals(intervals []hilbert.Interval) (*QueryToken, error) {
+	ranges := make([]Range, len(intervals))
+	for i, iv := range intervals {
+		ranges[i] = Range{Lower: iv.Lower, Upper: iv.Upper}
+	}
+	return s.QueryEncRanges(ranges...)
+}
@@ -0,0 +1,300 @@
+package pprq
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+/*
+	The binary format of the query token (all the integers are unsigned varints unless noted):
+
+	token:  header (kindToken, see marshal.go) | key ID (8 bytes) | interval
+	ranges: header (kindRanges) | key ID (8 bytes) | intervals
+	conjunction: header (kindConjunction) | key ID (8 bytes) | the number of conditions | conditions (in the order of evaluation)
+	condition: attribute | intervals
+	intervals: the number of intervals | intervals (ascending)
+	interval: lower bound | upper bound
+	bound: the number of blocks (0: unbounded, otherwise blockNum) | blocks (each: sub-index (1 byte) | len | cipher)
+
+	A token is written in the narrowest form: the token form for exactly one interval on attribute 0 (which the readers before kindRanges also understand), the ranges form for one condition on attribute 0, and the conjunction form otherwise.
+
+	The text form (MarshalText, also used by encoding/json) is the standard base64 encoding of the binary form.
+	The tokens of several keys (see Rotation) are encoded by MarshalTokens as the concatenation of their binary forms.
+*/
+
+// Params(): return the parameters which the query is generated with
+func (q *QueryToken) Params() Params {
+	return q.params
+}
+
+// KeyID(): return the key which the query is generated with
+func (q *QueryToken) KeyID() KeyID {
+	return q.keyID
+}
+
+// checkBound(*QueryRangeCipher, Params): check whether the block count and the sub-index values of one bound match the parameters
+func checkBound(bound *QueryRangeCipher, p Params) error {
+	if bound.blockCipher == nil { // the unbounded side
+		return nil
+	}
+	if len(bound.blockCipher) != p.blockNum() {
+		return malformedToken("%d blocks (want %d)", len(bound.blockCipher), p.blockNum())
+	}
+	for j := range bound.blockCipher {
+		if int(bound.blockCipher[j].subIndex) >= p.SubIndexSize {
+			return malformedToken("sub-index %d out of range in block %d", bound.blockCipher[j].subIndex, j)
+		}
+		if l := len(bound.blockCipher[j].cipher); l > sha256.Size || (p.Hashing == HashBytes && l != sha256.Size) {
+			return malformedToken("bad cipher length %d in block %d", len(bound.blockCipher[j].cipher), j)
+		}
+	}
+	return nil
+}
+
+// Check(Params): check whether the query can be evaluated on an index encrypted with the parameters p
+func (q *QueryToken) Check(p Params) error {
+	if q.params != p {
+		return mismatch(q.params, p)
+	}
+	if len(q.conditions) == 0 {
+		return malformedToken("no condition")
+	}
+	for _, c := range q.conditions {
+		if c.attribute < 0 || c.attribute >= maxAttributes {
+			return malformedToken("attribute %d out of range", c.attribute)
+		}
+		for i := range c.intervals {
+			if err := checkBound(&c.intervals[i].lower, p); err != nil {
+				return err
+			}
+			if err := checkBound(&c.intervals[i].upper, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Intervals(): return the number of intervals in the query (over all the conditions)
+func (q *QueryToken) Intervals() int {
+	n := 0
+	for _, c := range q.conditions {
+		n += len(c.intervals)
+	}
+	return n
+}
+
+// Conditions(): return the number of conditions in the query (1 unless generated by QueryEncConjunction)
+func (q *QueryToken) Conditions() int {
+	return len(q.conditions)
+}
+
+// appendBound([]byte, *QueryRangeCipher): append one bound to b
+func appendBound(b []byte, bound *QueryRangeCipher) []byte {
+	b = binary.AppendUvarint(b, uint64(len(bound.blockCipher)))
+	for j := range bound.blockCipher {
+		b = append(b, bound.blockCipher[j].subIndex)
+		b = binary.AppendUvarint(b, uint64(len(bound.blockCipher[j].cipher)))
+		b = append(b, bound.blockCipher[j].cipher...)
+	}
+	return b
+}
+
+// readBound(*bytes.Reader, Params): read one bound generated with the parameters p
+func readBound(r *bytes.Reader, p Params) (QueryRangeCipher, error) {
+	var bound QueryRangeCipher
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return bound, malformedToken("truncated block count")
+	}
+	if n == 0 { // the unbounded side
+		return bound, nil
+	}
+	if n != uint64(p.blockNum()) {
+		return bound, malformedToken("%d blocks (want %d)", n, p.blockNum())
+	}
+	bound.blockCipher = make([]QueryBlockCipher, n)
+	for j := range bound.blockCipher {
+		block := &bound.blockCipher[j]
+		if block.subIndex, err = r.ReadByte(); err != nil {
+			return bound, malformedToken("truncated block %d", j)
+		}
+		l, err := binary.ReadUvarint(r)
+		if err != nil || l > uint64(sha256.Size) || l > uint64(r.Len()) {
+			return bound, malformedToken("bad cipher in block %d", j)
+		}
+		block.cipher = make([]byte, l)
+		r.Read(block.cipher)
+	}
+	return bound, checkBound(&bound, p)
+}
+
+// MarshalBinary(): encode the query token
+func (q *QueryToken) MarshalBinary() ([]byte, error) {
+	if err := q.Check(q.params); err != nil {
+		return nil, err
+	}
+	single := len(q.conditions) == 1 && q.conditions[0].attribute == 0
+	var b []byte
+	switch {
+	case single && len(q.conditions[0].intervals) == 1:
+		b = appendHeader(nil, kindToken, q.params)
+		b = append(b, q.keyID[:]...)
+		return appendIntervals(b, q.conditions[0].intervals), nil
+	case single:
+		b = appendHeader(nil, kindRanges, q.params)
+		b = append(b, q.keyID[:]...)
+		b = binary.AppendUvarint(b, uint64(len(q.conditions[0].intervals)))
+		return appendIntervals(b, q.conditions[0].intervals), nil
+	}
+	b = appendHeader(nil, kindConjunction, q.params)
+	b = append(b, q.keyID[:]...)
+	b = binary.AppendUvarint(b, uint64(len(q.conditions)))
+	for _, c := range q.conditions {
+		b = binary.AppendUvarint(b, uint64(c.attribute))
+		b = binary.AppendUvarint(b, uint64(len(c.intervals)))
+		b = appendIntervals(b, c.intervals)
+	}
+	return b, nil
+}
+
+// appendIntervals([]byte, []QueryIntervalCipher): append the bounds of the intervals to b
+func appendIntervals(b []byte, intervals []QueryIntervalCipher) []byte {
+	for i := range intervals {
+		b = appendBound(b, &intervals[i].lower)
+		b = appendBound(b, &intervals[i].upper)
+	}
+	return b
+}
+
+// readIntervals(*bytes.Reader, Params, bool): read the intervals of one condition (with their count if counted, otherwise exactly one)
+func readIntervals(r *bytes.Reader, p Params, counted bool) ([]QueryIntervalCipher, error) {
+	n := uint64(1)
+	if counted {
+		var err error
+		if n, err = binary.ReadUvarint(r); err != nil || n > uint64(r.Len()/2) { // every interval takes at least 2 bytes
+			return nil, malformedToken("bad interval count")
+		}
+	}
+	intervals := make([]QueryIntervalCipher, n)
+	for i := range intervals {
+		var err error
+		if intervals[i].lower, err = readBound(r, p); err != nil {
+			return nil, err
+		}
+		if intervals[i].upper, err = readBound(r, p); err != nil {
+			return nil, err
+		}
+	}
+	return intervals, nil
+}
+
+// readToken(*bytes.Reader): read one query token
+func readToken(r *bytes.Reader) (*QueryToken, error) {
+	var decoded QueryToken
+	h, err := readHeaderKind(r)
+	kind := h.kind
+	decoded.params = h.params
+	if err != nil || (kind != kindToken && kind != kindRanges && kind != kindConjunction) {
+		if err == nil || err == io.EOF || errors.Is(err, ErrMalformedIndex) {
+			return nil, malformedToken("bad header")
+		}
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, decoded.keyID[:]); err != nil {
+		return nil, malformedToken("truncated key ID")
+	}
+	if kind != kindConjunction {
+		intervals, err := readIntervals(r, decoded.params, kind == kindRanges)
+		if err != nil {
+			return nil, err
+		}
+		decoded.conditions = []QueryConditionCipher{{attribute: 0, intervals: intervals}}
+		return &decoded, nil
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n == 0 || n > uint64(r.Len()/2) { // every condition takes at least 2 bytes
+		return nil, malformedToken("bad condition count")
+	}
+	decoded.conditions = make([]QueryConditionCipher, n)
+	for i := range decoded.conditions {
+		a, err := binary.ReadUvarint(r)
+		if err != nil || a >= uint64(maxAttributes) {
+			return nil, malformedToken("bad attribute in condition %d", i)
+		}
+		decoded.conditions[i].attribute = int(a)
+		if decoded.conditions[i].intervals, err = readIntervals(r, decoded.params, true); err != nil {
+			return nil, err
+		}
+	}
+	return &decoded, nil
+}
+
+// UnmarshalBinary([]byte): decode a query token encoded by MarshalBinary
+func (q *QueryToken) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	decoded, err := readToken(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return malformedToken("%d trailing bytes", r.Len())
+	}
+	*q = *decoded
+	return nil
+}
+
+// MarshalTokens(...*QueryToken): encode the tokens of one query under several keys
+func MarshalTokens(tokens ...*QueryToken) ([]byte, error) {
+	var b []byte
+	for _, q := range tokens {
+		data, err := q.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, data...)
+	}
+	return b, nil
+}
+
+// UnmarshalTokens([]byte): decode the tokens encoded by MarshalTokens (a single token encoded by MarshalBinary is also accepted)
+func UnmarshalTokens(data []byte) ([]*QueryToken, error) {
+	var tokens []*QueryToken
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		q, err := readToken(r)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, q)
+	}
+	if len(tokens) == 0 {
+		return nil, malformedToken("no query token")
+	}
+	return tokens, nil
+}
+
+// MarshalText(): encode the query token as base64 (the token is a JSON string in encoding/json)
+func (q *QueryToken) MarshalText() ([]byte, error) {
+	b, err := q.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	text := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(text, b)
+	return text, nil
+}
+
+// UnmarshalText([]byte): decode a query token encoded by MarshalText
+func (q *QueryToken) UnmarshalText(text []byte) error {
+	b := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Decode(b, text)
+	if err != nil {
+		return malformedToken("%v", err)
+	}
+	return q.UnmarshalBinary(b[:n])
+}
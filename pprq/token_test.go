@@ -0,0 +1,197 @@
+package pprq
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// checkToken(*testing.T, *Index, *QueryToken, []ItemID): check that the token matches exactly the IDs want
+func checkToken(t *testing.T, idx *Index, q *QueryToken, want []ItemID) {
+	t.Helper()
+	ids, err := idx.Match(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	s := newTestScheme(t, DefaultParams())
+	idx, err := s.IndexEnc([]uint64{5, 10, 15, 20, 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, err := s.QueryEncRanges(Range{Lower: 10, Upper: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unbounded, err := s.QueryEncRanges(Range{Lower: 0, Upper: 12}) // the lower side is unbounded
+	if err != nil {
+		t.Fatal(err)
+	}
+	union, err := s.QueryEncRanges(Range{Lower: 0, Upper: 6}, Range{Lower: 24, Upper: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		q    *QueryToken
+		kind byte
+		want []ItemID
+	}{
+		{"single", single, kindToken, []ItemID{1, 2, 3}},
+		{"unbounded", unbounded, kindToken, []ItemID{0, 1}},
+		{"union", union, kindRanges, []ItemID{0, 4}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			b, err := c.q.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b[5] != c.kind {
+				t.Errorf("kind %d, want %d", b[5], c.kind)
+			}
+			var decoded QueryToken
+			if err := decoded.UnmarshalBinary(b); err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Params() != c.q.Params() || decoded.KeyID() != c.q.KeyID() || decoded.Intervals() != c.q.Intervals() {
+				t.Errorf("got %+v %v %d, want %+v %v %d", decoded.Params(), decoded.KeyID(), decoded.Intervals(), c.q.Params(), c.q.KeyID(), c.q.Intervals())
+			}
+			checkToken(t, idx, &decoded, c.want)
+
+			// the text form, also inside JSON
+			text, err := json.Marshal(map[string]*QueryToken{"token": c.q})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fromJSON map[string]*QueryToken
+			if err := json.Unmarshal(text, &fromJSON); err != nil {
+				t.Fatal(err)
+			}
+			checkToken(t, idx, fromJSON["token"], c.want)
+		})
+	}
+
+	// the tokens of several keys
+	other := newTestScheme(t, DefaultParams())
+	otherToken, err := other.QueryEncRanges(Range{Lower: 10, Upper: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := MarshalTokens(single, otherToken, union)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := UnmarshalTokens(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 3 || tokens[1].KeyID() != other.Key().ID() || tokens[2].Intervals() != 2 {
+		t.Fatalf("got %d tokens", len(tokens))
+	}
+	one, err := single.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens, err := UnmarshalTokens(one); err != nil || len(tokens) != 1 {
+		t.Errorf("a single token: got %d tokens, %v", len(tokens), err)
+	}
+}
+
+func TestTokenMalformed(t *testing.T) {
+	s := newTestScheme(t, DefaultParams())
+	q, err := s.QueryEncRanges(Range{Lower: 10, Upper: 20}, Range{Lower: 30, Upper: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := q.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := s.IndexItemEnc(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ib, err := item.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// modify(...): copy the token encoding and change it
+	modify := func(f func([]byte) []byte) []byte {
+		return f(slices.Clone(b))
+	}
+	body := headerSize + len(KeyID{}) // the position of the interval count
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", modify(func(d []byte) []byte { d[0] = 'X'; return d })},
+		{"future version", modify(func(d []byte) []byte { d[4] = formatVersion + 1; return d })},
+		{"item", ib},
+		{"invalid params", modify(func(d []byte) []byte { d[7] = 0; return d })},
+		{"trailing bytes", append(slices.Clone(b), 0)},
+		{"huge interval count", modify(func(d []byte) []byte { d[body] = 0x7f; return d })},
+		{"bad block count", modify(func(d []byte) []byte { d[body+1] = 3; return d })},
+		{"bad sub-index", modify(func(d []byte) []byte { d[body+2] = 3; return d })},
+		{"bad cipher length", modify(func(d []byte) []byte { d[body+3] = 33; return d })},
+	}
+	for n := 1; n < len(b); n++ {
+		cases = append(cases, struct {
+			name string
+			data []byte
+		}{"truncated", b[:n]})
+	}
+	for _, c := range cases {
+		var decoded QueryToken
+		if err := decoded.UnmarshalBinary(c.data); !errors.Is(err, ErrMalformedToken) {
+			t.Errorf("%s (%d bytes): got %v, want ErrMalformedToken", c.name, len(c.data), err)
+		}
+	}
+	if _, err := UnmarshalTokens(nil); !errors.Is(err, ErrMalformedToken) {
+		t.Errorf("no token: got %v, want ErrMalformedToken", err)
+	}
+	var decoded QueryToken
+	if err := decoded.UnmarshalText([]byte("not base64!")); !errors.Is(err, ErrMalformedToken) {
+		t.Errorf("bad base64: got %v, want ErrMalformedToken", err)
+	}
+}
+
+func TestTokenParamMismatch(t *testing.T) {
+	s := newTestScheme(t, DefaultParams())
+	other := newTestScheme(t, Params{BlockSize: 4, SubIndexSize: 15, DomainBits: 32})
+	idx, err := s.IndexEnc([]uint64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := other.QueryEncRanges(Range{Lower: 0, Upper: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Check(s.Params()); !errors.Is(err, ErrParamMismatch) {
+		t.Errorf("Check: got %v, want ErrParamMismatch", err)
+	}
+	if _, err := idx.Match(q); !errors.Is(err, ErrParamMismatch) {
+		t.Errorf("Match: got %v, want ErrParamMismatch", err)
+	}
+
+	// the decoded token keeps the parameters of its header
+	b, err := q.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded QueryToken
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Match(&decoded); !errors.Is(err, ErrParamMismatch) {
+		t.Errorf("Match of the decoded token: got %v, want ErrParamMismatch", err)
+	}
+}
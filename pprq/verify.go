@@ -0,0 +1,113 @@
+package pprq
+
+import (
+	"context"
+	"fmt"
+)
+
+// Mismatch: one item which the search and the plaintext scan disagree on
+type Mismatch struct {
+	ID    ItemID `json:"id"`    // the ID of the item
+	Value uint64 `json:"value"` // the plaintext value of the item
+}
+
+// VerifyReport: the result of one search compared with the plaintext scan of the same query (see Scheme.Verify)
+type VerifyReport struct {
+	Lower          uint64      `json:"lower"`          // the lower bound of the query (inclusive)
+	Upper          uint64      `json:"upper"`          // the upper bound of the query (inclusive)
+	Returned       int         `json:"returned"`       // the number of items returned by the search
+	Expected       int         `json:"expected"`       // the number of items in the range by the plaintext scan
+	FalsePositives []Mismatch  `json:"falsePositives"` // the items returned by the search but out of the range, in ascending order of ID
+	FalseNegatives []Mismatch  `json:"falseNegatives"` // the items in the range but missed by the search, in ascending order of ID
+	Stats          SearchStats `json:"stats"`          // the counters of the search
+}
+
+// Precision(): the fraction of the returned items which are in the range (1 if nothing is returned)
+func (r *VerifyReport) Precision() float64 {
+	if r.Returned == 0 {
+		return 1
+	}
+	return float64(r.Returned-len(r.FalsePositives)) / float64(r.Returned)
+}
+
+// Recall(): the fraction of the items in the range which are returned (1 if no item is in the range)
+func (r *VerifyReport) Recall() float64 {
+	if r.Expected == 0 {
+		return 1
+	}
+	return float64(r.Expected-len(r.FalseNegatives)) / float64(r.Expected)
+}
+
+// OK(): whether the search returned exactly the items in the range
+func (r *VerifyReport) OK() bool {
+	return len(r.FalsePositives) == 0 && len(r.FalseNegatives) == 0
+}
+
+// String(): summarize the report in one line
+func (r *VerifyReport) String() string {
+	return fmt.Sprintf("[%d,%d]: returned %d, expected %d, precision %.4f, recall %.4f, %d false positives, %d false negatives",
+		r.Lower, r.Upper, r.Returned, r.Expected, r.Precision(), r.Recall(), len(r.FalsePositives), len(r.FalseNegatives))
+}
+
+// PlainValues(*Index): recover the plaintext values of the items encrypted with the key of the scheme by opening their payloads (the items of the other keys are left out)
+func (s *Scheme) PlainValues(idx *Index) (map[ItemID]uint64, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	values := make(map[ItemID]uint64, len(idx.items))
+	for i := range idx.items {
+		if idx.deleted(i) || idx.items[i].keyID != s.key.id {
+			continue
+		}
+		pl, err := s.OpenPayload(idx.items[i].payload)
+		if err != nil {
+			return nil, fmt.Errorf("item %s: %w", idx.ids[i], err)
+		}
+		values[idx.ids[i]] = pl.Value
+	}
+	return values, nil
+}
+
+// Verify(*Index, map[ItemID]uint64, uint64, uint64): search the index with the token of [lowerBound,upperBound] and compare the result with the plaintext scan of values (the plaintext values of the items, e.g. from PlainValues). only the items encrypted with the key of the scheme take part, and each of them must have its value
+func (s *Scheme) Verify(idx *Index, values map[ItemID]uint64, lowerBound uint64, upperBound uint64) (*VerifyReport, error) {
+	q, err := s.QueryEncRanges(Range{Lower: lowerBound, Upper: upperBound})
+	if err != nil {
+		return nil, err
+	}
+	r := &VerifyReport{Lower: lowerBound, Upper: upperBound}
+
+	// the search and the scan run under the same read lock, so they see the same items
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	slots, err := idx.match(context.Background(), SearchOptions{Stats: &r.Stats}, []*QueryToken{q})
+	if err != nil {
+		return nil, err
+	}
+	r.Returned = len(slots)
+
+	next := 0 // the next matched position
+	for i := range idx.items {
+		if idx.deleted(i) || idx.items[i].keyID != s.key.id {
+			continue
+		}
+		id := idx.ids[i]
+		v, ok := values[id]
+		if !ok {
+			return nil, wrapError(ErrNoSuchItem, "no plaintext value of item %s", id)
+		}
+		returned := next < len(slots) && slots[next] == i
+		if returned {
+			next++
+		}
+		inRange := v >= lowerBound && v <= upperBound
+		if inRange {
+			r.Expected++
+		}
+		switch {
+		case returned && !inRange:
+			r.FalsePositives = append(r.FalsePositives, Mismatch{ID: id, Value: v})
+		case !returned && inRange:
+			r.FalseNegatives = append(r.FalseNegatives, Mismatch{ID: id, Value: v})
+		}
+	}
+	return r, nil
+}